@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/jizhilong/branch-bot/config"
 	"github.com/jizhilong/branch-bot/gitlab"
@@ -20,13 +23,16 @@ func main() {
 		slog.Error("Failed to load configuration", "error", err)
 		os.Exit(1)
 	}
-	webhook, err := gitlab.NewWebhook(cfg.GitlabUrl, cfg.GitlabToken, cfg.RepoDirectory, cfg.BranchNamePrefix, cfg.ListenPort)
+	webhook, err := gitlab.NewWebhook(cfg)
 	if err != nil {
 		slog.Error("Failed to create webhook", "error", err)
 		os.Exit(1)
 	}
 	slog.Info("Starting webhook", "gitlab", cfg.GitlabUrl, "branchNamePrefix", cfg.BranchNamePrefix, "port", cfg.ListenPort)
-	err = webhook.Start()
+	// commands being processed are canceled on shutdown, leaving the repositories clean
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = webhook.Start(ctx)
 	if err != nil {
 		slog.Error("Failed to start webhook", "error", err)
 		os.Exit(1)
@@ -1,8 +1,15 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jizhilong/branch-bot/models"
 )
 
 type Config struct {
@@ -13,15 +20,143 @@ type Config struct {
 	// BranchNamePrefix output branch will be named as BranchNamePrefix + issue iid
 	BranchNamePrefix string
 	ListenPort       int
+	// CommandTimeout limits the time of processing a command, including all git operations
+	CommandTimeout time.Duration
+	// RepoDiskBudget limits the total size of clones in bytes, zero means no limit
+	RepoDiskBudget int64
+	// RepoIdleTimeout evicts clones not used for the duration, zero keeps idle clones
+	RepoIdleTimeout time.Duration
+	// RepoMaintenanceInterval is the interval of checking, garbage collecting and evicting clones,
+	// zero disables maintenance
+	RepoMaintenanceInterval time.Duration
+	// Default holds the settings of all projects, loaded from BB_CONFIG_FILE
+	Default ProjectConfig
+	// Projects overrides the default settings per project path with namespace, loaded from BB_CONFIG_FILE
+	Projects map[string]ProjectConfig
+}
+
+// ProjectConfig holds settings that can be customized per project
+type ProjectConfig struct {
+	// MergeStrategies resolve merge conflicts of matching paths automatically
+	MergeStrategies []models.PathMergeStrategy `json:"mergeStrategies,omitempty"`
+	// Validations are shell commands run in a worktree of the merge result before a branch is added
+	Validations []string `json:"validations,omitempty"`
+	// ValidationTimeout limits the time of running all validations, defaults to 10 minutes
+	ValidationTimeout Duration `json:"validationTimeout,omitempty"`
+	// Timeouts limit the time of git operations
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+	// CloneFilter makes new clones partial, blob:none for blobless or tree:0 for treeless clones
+	CloneFilter string `json:"cloneFilter,omitempty"`
+	// ReferenceRepository is a local repository sharing its objects with new clones
+	ReferenceRepository string `json:"referenceRepository,omitempty"`
+	// Identity is the author and committer of bb commits, defaults to branch-bot
+	Identity *Identity `json:"identity,omitempty"`
+	// Signing signs bb commits, they are not signed by default
+	Signing *Signing `json:"signing,omitempty"`
+	// Transport is how repositories are cloned and pushed, https with the GitLab token by default,
+	// or ssh with the deploy key in SSH
+	Transport string `json:"transport,omitempty"`
+	// SSH holds the deploy key and known hosts of the ssh transport
+	SSH *SSH `json:"ssh,omitempty"`
+	// IssueTemplate is the path of a text/template file rendering the status in the issue description
+	IssueTemplate string `json:"issueTemplate,omitempty"`
+}
+
+// Transports of cloning and pushing repositories
+const (
+	TransportHTTPS = "https"
+	TransportSSH   = "ssh"
+)
+
+// SSH holds the settings of the ssh transport
+type SSH struct {
+	// KeyFile is the path of the private deploy key without passphrase
+	KeyFile string `json:"keyFile"`
+	// KnownHostsFile is the path of a known_hosts file holding the host keys of GitLab
+	KnownHostsFile string `json:"knownHostsFile"`
+}
+
+// Identity is the name and email recorded in commits
+type Identity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Signing signs commits with a private key without passphrase
+type Signing struct {
+	// Format is openpgp or ssh
+	Format string `json:"format"`
+	// KeyFile is the path of the private key, an armored secret key for openpgp
+	KeyFile string `json:"keyFile"`
+}
+
+// Timeouts limit the time of git operations
+type Timeouts struct {
+	Clone Duration `json:"clone,omitempty"`
+	Fetch Duration `json:"fetch,omitempty"`
+	Merge Duration `json:"merge,omitempty"`
+	Push  Duration `json:"push,omitempty"`
+}
+
+var defaultTimeouts = Timeouts{
+	Clone: Duration(30 * time.Minute),
+	Fetch: Duration(5 * time.Minute),
+	Merge: Duration(5 * time.Minute),
+	Push:  Duration(5 * time.Minute),
+}
+
+// withDefaults returns the timeouts with unset ones taken from defaults
+func (t Timeouts) withDefaults(defaults Timeouts) Timeouts {
+	for _, pair := range []struct{ value, fallback *Duration }{
+		{&t.Clone, &defaults.Clone},
+		{&t.Fetch, &defaults.Fetch},
+		{&t.Merge, &defaults.Merge},
+		{&t.Push, &defaults.Push},
+	} {
+		if *pair.value == 0 {
+			*pair.value = *pair.fallback
+		}
+	}
+	return t
+}
+
+// Duration is a time.Duration read from strings like "90s" or "5m" in json
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// configFile is the format of BB_CONFIG_FILE
+type configFile struct {
+	Default  ProjectConfig            `json:"default"`
+	Projects map[string]ProjectConfig `json:"projects"`
 }
 
 func Load() (*Config, error) {
 	config := &Config{
-		GitlabUrl:        os.Getenv("BB_GITLAB_URL"),
-		GitlabToken:      os.Getenv("BB_GITLAB_TOKEN"),
-		RepoDirectory:    os.Getenv("BB_REPO_DIRECTORY"),
-		BranchNamePrefix: os.Getenv("BB_BRANCH_NAME_PREFIX"),
-		ListenPort:       8181,
+		GitlabUrl:               os.Getenv("BB_GITLAB_URL"),
+		GitlabToken:             os.Getenv("BB_GITLAB_TOKEN"),
+		RepoDirectory:           os.Getenv("BB_REPO_DIRECTORY"),
+		BranchNamePrefix:        os.Getenv("BB_BRANCH_NAME_PREFIX"),
+		ListenPort:              8181,
+		CommandTimeout:          time.Hour,
+		RepoIdleTimeout:         7 * 24 * time.Hour,
+		RepoMaintenanceInterval: time.Hour,
+		Default:                 ProjectConfig{Timeouts: defaultTimeouts},
 	}
 	var errors []string
 	if config.GitlabUrl == "" {
@@ -39,5 +174,148 @@ func Load() (*Config, error) {
 	if len(errors) > 0 {
 		return nil, fmt.Errorf("missing required environment variables: %s", errors)
 	}
+	if timeout := os.Getenv("BB_COMMAND_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid BB_COMMAND_TIMEOUT %q, expected a positive duration like 30m", timeout)
+		}
+		config.CommandTimeout = d
+	}
+	if budget := os.Getenv("BB_REPO_DISK_BUDGET_MB"); budget != "" {
+		mb, err := strconv.ParseInt(budget, 10, 64)
+		if err != nil || mb < 0 {
+			return nil, fmt.Errorf("invalid BB_REPO_DISK_BUDGET_MB %q, expected a number of megabytes", budget)
+		}
+		config.RepoDiskBudget = mb << 20
+	}
+	for name, target := range map[string]*time.Duration{
+		"BB_REPO_IDLE_TIMEOUT":         &config.RepoIdleTimeout,
+		"BB_REPO_MAINTENANCE_INTERVAL": &config.RepoMaintenanceInterval,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid %s %q, expected a duration like 24h, or 0 to disable", name, value)
+			}
+			*target = d
+		}
+	}
+	if path := os.Getenv("BB_CONFIG_FILE"); path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to load config file %s: %w", path, err)
+		}
+	}
 	return config, nil
 }
+
+// loadFile loads project settings from a json file
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if err := file.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for name, project := range file.Projects {
+		if err := project.validate(); err != nil {
+			return fmt.Errorf("project %s: %w", name, err)
+		}
+	}
+	if file.Default.ValidationTimeout == 0 {
+		file.Default.ValidationTimeout = Duration(10 * time.Minute)
+	}
+	file.Default.Timeouts = file.Default.Timeouts.withDefaults(defaultTimeouts)
+	c.Default, c.Projects = file.Default, file.Projects
+	// the transport and the ssh settings may come from the default and a project separately
+	if c.Default.Transport == TransportSSH && c.Default.SSH == nil {
+		return fmt.Errorf("default: ssh transport requires ssh settings")
+	}
+	for name := range c.Projects {
+		if project := c.Project(name); project.Transport == TransportSSH && project.SSH == nil {
+			return fmt.Errorf("project %s: ssh transport requires ssh settings", name)
+		}
+	}
+	return nil
+}
+
+// Project returns the settings of a project, settings not set for the project fall back to the default
+func (c *Config) Project(pathWithNamespace string) ProjectConfig {
+	result := c.Default
+	project, ok := c.Projects[pathWithNamespace]
+	if !ok {
+		return result
+	}
+	if project.MergeStrategies != nil {
+		result.MergeStrategies = project.MergeStrategies
+	}
+	if project.Validations != nil {
+		result.Validations = project.Validations
+	}
+	if project.ValidationTimeout != 0 {
+		result.ValidationTimeout = project.ValidationTimeout
+	}
+	result.Timeouts = project.Timeouts.withDefaults(c.Default.Timeouts)
+	if project.CloneFilter != "" {
+		result.CloneFilter = project.CloneFilter
+	}
+	if project.ReferenceRepository != "" {
+		result.ReferenceRepository = project.ReferenceRepository
+	}
+	if project.Identity != nil {
+		result.Identity = project.Identity
+	}
+	if project.Signing != nil {
+		result.Signing = project.Signing
+	}
+	if project.Transport != "" {
+		result.Transport = project.Transport
+	}
+	if project.SSH != nil {
+		result.SSH = project.SSH
+	}
+	if project.IssueTemplate != "" {
+		result.IssueTemplate = project.IssueTemplate
+	}
+	return result
+}
+
+func (p ProjectConfig) validate() error {
+	if p.CloneFilter != "" && !strings.HasPrefix(p.CloneFilter, "blob:") && !strings.HasPrefix(p.CloneFilter, "tree:") {
+		return fmt.Errorf("unsupported clone filter %q, expected blob:none or tree:0", p.CloneFilter)
+	}
+	if p.Identity != nil && (p.Identity.Name == "" || p.Identity.Email == "") {
+		return fmt.Errorf("identity requires a name and an email")
+	}
+	if p.Transport != "" && p.Transport != TransportHTTPS && p.Transport != TransportSSH {
+		return fmt.Errorf("unsupported transport %q, expected %s or %s", p.Transport, TransportHTTPS, TransportSSH)
+	}
+	if p.SSH != nil && (p.SSH.KeyFile == "" || p.SSH.KnownHostsFile == "") {
+		return fmt.Errorf("ssh requires a keyFile and a knownHostsFile")
+	}
+	if p.Signing != nil {
+		if p.Signing.Format != "openpgp" && p.Signing.Format != "ssh" {
+			return fmt.Errorf("unsupported signing format %q, expected openpgp or ssh", p.Signing.Format)
+		}
+		if p.Signing.KeyFile == "" {
+			return fmt.Errorf("signing requires a keyFile")
+		}
+	}
+	strategies := []string{models.MergeStrategyUnion, models.MergeStrategyOurs, models.MergeStrategyTheirs, models.MergeStrategyCommand}
+	for _, s := range p.MergeStrategies {
+		if s.Pattern == "" {
+			return fmt.Errorf("merge strategy without pattern")
+		}
+		if !slices.Contains(strategies, s.Strategy) {
+			return fmt.Errorf("unknown merge strategy %q for %s", s.Strategy, s.Pattern)
+		}
+		if s.Strategy == models.MergeStrategyCommand && s.Command == "" {
+			return fmt.Errorf("merge strategy command for %s requires a command", s.Pattern)
+		}
+	}
+	return nil
+}
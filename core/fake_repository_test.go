@@ -0,0 +1,294 @@
+package core
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jizhilong/branch-bot/models"
+)
+
+// fakeCommit is a commit of fakeRepository
+type fakeCommit struct {
+	message string
+	parents []string
+	time    time.Time
+}
+
+// fakeRepository is an in-memory Repository for testing operator logic without running git.
+//
+// Commits only have messages and parents, merges succeed unless they bring together commits scripted to
+// conflict, and failures of other operations are scripted through the error fields.
+type fakeRepository struct {
+	commits  map[string]fakeCommit
+	branches map[string]string // local branches
+	remote   map[string]string // branches on origin
+	// unfetched are commits only on origin, they are added to commits once fetched by id
+	unfetched map[string]fakeCommit
+	// conflicts lists pairs of commits whose changes conflict, a commit containing both resolves the conflict
+	conflicts [][2]string
+	// diffStats are returned by DiffStat per target commit
+	diffStats map[string][]models.FileDiffStat
+
+	fetchErr    error
+	pushErr     error
+	validateErr error
+
+	fetched []string
+	pushed  []string // remote branches pushed, in order
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		commits:   make(map[string]fakeCommit),
+		branches:  make(map[string]string),
+		remote:    make(map[string]string),
+		unfetched: make(map[string]fakeCommit),
+		diffStats: make(map[string][]models.FileDiffStat),
+	}
+}
+
+// commit creates a commit with parents, returning a deterministic hash
+func (r *fakeRepository) commit(message string, parents ...string) string {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(message+"\x00"+strings.Join(parents, " "))))
+	r.commits[hash] = fakeCommit{message: message, parents: parents, time: time.Now()}
+	return hash
+}
+
+// branch creates a commit on top of parent and points a remote branch to it, as a developer pushing
+func (r *fakeRepository) branch(name, parent, message string) *models.GitRef {
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+	commit := r.commit(message, parents...)
+	r.remote[name] = commit
+	return &models.GitRef{Name: name, Commit: commit}
+}
+
+// conflict scripts the changes of two commits to conflict when merged together
+func (r *fakeRepository) conflict(commit1, commit2 string) {
+	r.conflicts = append(r.conflicts, [2]string{commit1, commit2})
+}
+
+// ancestors returns commit and all commits reachable from it
+func (r *fakeRepository) ancestors(commit string) map[string]bool {
+	seen := make(map[string]bool)
+	queue := []string{commit}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		queue = append(queue, r.commits[c].parents...)
+	}
+	return seen
+}
+
+func (r *fakeRepository) RevParse(_ context.Context, rev string) (string, error) {
+	rev = strings.TrimSuffix(rev, "^{commit}")
+	if name, ok := strings.CutPrefix(rev, "refs/remotes/origin/"); ok {
+		if commit, ok := r.remote[name]; ok {
+			return commit, nil
+		}
+	} else if commit, ok := r.branches[rev]; ok {
+		return commit, nil
+	} else if _, ok := r.commits[rev]; ok {
+		return rev, nil
+	}
+	return "", fmt.Errorf("unknown revision %s", rev)
+}
+
+func (r *fakeRepository) AheadBehind(_ context.Context, commit, base string) (int, int, error) {
+	if _, ok := r.commits[commit]; !ok {
+		return 0, 0, fmt.Errorf("unknown commit %s", commit)
+	}
+	if _, ok := r.commits[base]; !ok {
+		return 0, 0, fmt.Errorf("unknown commit %s", base)
+	}
+	commits, bases := r.ancestors(commit), r.ancestors(base)
+	ahead, behind := 0, 0
+	for c := range commits {
+		if !bases[c] {
+			ahead++
+		}
+	}
+	for c := range bases {
+		if !commits[c] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func (r *fakeRepository) Log(_ context.Context, from, to string, limit int) ([]models.CommitSummary, error) {
+	if _, ok := r.commits[to]; !ok {
+		return nil, fmt.Errorf("unknown commit %s", to)
+	}
+	excluded := r.ancestors(from)
+	var commits []models.CommitSummary
+	seen := make(map[string]bool)
+	// breadth first from to, which lists children before their parents
+	queue := []string{to}
+	for len(queue) > 0 && len(commits) < limit {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c] || excluded[c] {
+			continue
+		}
+		seen[c] = true
+		commits = append(commits, models.CommitSummary{SHA: c, Title: r.commits[c].message})
+		queue = append(queue, r.commits[c].parents...)
+	}
+	return commits, nil
+}
+
+func (r *fakeRepository) CommitTime(_ context.Context, commit string) (time.Time, error) {
+	c, ok := r.commits[commit]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown commit %s", commit)
+	}
+	return c.time, nil
+}
+
+func (r *fakeRepository) GetCommitMessage(_ context.Context, commit string) (string, error) {
+	c, ok := r.commits[commit]
+	if !ok {
+		return "", fmt.Errorf("unknown commit %s", commit)
+	}
+	return c.message, nil
+}
+
+func (r *fakeRepository) Merge(_ context.Context, message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitMergeResult, error) {
+	refs := append([]*models.GitRef{base}, commits...)
+	if len(refs) == 1 {
+		return &models.GitMergeResult{GitRef: *base}, nil
+	}
+	contained := make([]map[string]bool, len(refs))
+	for i, ref := range refs {
+		if _, ok := r.commits[ref.Commit]; !ok {
+			return nil, fmt.Errorf("unknown commit %s", ref.Commit)
+		}
+		contained[i] = r.ancestors(ref.Commit)
+	}
+	for _, pair := range r.conflicts {
+		var sides [2][]string
+		resolved := false
+		for i, ref := range refs {
+			if contained[i][pair[0]] && contained[i][pair[1]] {
+				resolved = true
+			} else if contained[i][pair[0]] {
+				sides[0] = append(sides[0], ref.Name)
+			} else if contained[i][pair[1]] {
+				sides[1] = append(sides[1], ref.Name)
+			}
+		}
+		if !resolved && len(sides[0]) > 0 && len(sides[1]) > 0 {
+			return nil, &models.GitMergeFailResult{
+				CommandExecFail: models.CommandExecFail{
+					Cmdline: "git merge",
+					Status:  "exit status 1",
+					Stderr:  "Automatic merge failed; fix conflicts and then commit the result.",
+				},
+				ConflictBranches: []string{sides[0][0], sides[1][0]},
+				ConflictMinimal:  true,
+			}
+		}
+	}
+	parents := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		parents = append(parents, ref.Commit)
+	}
+	return &models.GitMergeResult{GitRef: models.GitRef{Commit: r.commit(message, parents...)}}, nil
+}
+
+func (r *fakeRepository) RewordCommit(_ context.Context, commit, message string) (string, error) {
+	c, ok := r.commits[commit]
+	if !ok {
+		return "", fmt.Errorf("unknown commit %s", commit)
+	}
+	return r.commit(message, c.parents...), nil
+}
+
+func (r *fakeRepository) Validate(context.Context, string, []string, time.Duration) error {
+	return r.validateErr
+}
+
+func (r *fakeRepository) IsAncestor(_ context.Context, ancestor, commit string) (bool, error) {
+	return r.ancestors(commit)[ancestor], nil
+}
+
+func (r *fakeRepository) MergeBase(_ context.Context, commit string, others ...string) (string, error) {
+	merged := make(map[string]bool)
+	for _, other := range others {
+		maps.Copy(merged, r.ancestors(other))
+	}
+	common := r.ancestors(commit)
+	for c := range common {
+		if !merged[c] {
+			delete(common, c)
+		}
+	}
+	// the best common ancestor isn't an ancestor of any other common ancestor
+	for c := range common {
+		best := true
+		for other := range common {
+			if other != c && r.ancestors(other)[c] {
+				best = false
+				break
+			}
+		}
+		if best {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("no merge base of %s and %s", commit, strings.Join(others, " "))
+}
+
+func (r *fakeRepository) DiffStat(_ context.Context, _, to string) ([]models.FileDiffStat, error) {
+	return r.diffStats[to], nil
+}
+
+func (r *fakeRepository) EnsureBranch(_ context.Context, name string, commit string) error {
+	if commit == "" {
+		delete(r.branches, name)
+		return nil
+	}
+	r.branches[name] = commit
+	return nil
+}
+
+func (r *fakeRepository) Fetch(_ context.Context, refs ...string) error {
+	if r.fetchErr != nil {
+		return r.fetchErr
+	}
+	for _, ref := range refs {
+		if !slices.Contains(r.fetched, ref) {
+			r.fetched = append(r.fetched, ref)
+		}
+		if commit, ok := r.unfetched[ref]; ok {
+			r.commits[ref] = commit
+			delete(r.unfetched, ref)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRepository) PushRemote(_ context.Context, _, branch, commit string) error {
+	if r.pushErr != nil {
+		return r.pushErr
+	}
+	r.pushed = append(r.pushed, branch)
+	if commit == "" {
+		delete(r.remote, branch)
+		return nil
+	}
+	r.remote[branch] = commit
+	return nil
+}
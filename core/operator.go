@@ -1,7 +1,13 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jizhilong/branch-bot/git"
 	"github.com/jizhilong/branch-bot/models"
@@ -9,8 +15,13 @@ import (
 
 // MergeTrainOperator handles operations on a merge train
 type MergeTrainOperator struct {
-	repo       *git.Repo
+	repo       Repository
 	mergeTrain *models.MergeTrain
+	// validations are commands run against the merge result before a branch is added
+	validations       []string
+	validationTimeout time.Duration
+	// baseBranch is the branch members are compared with in the view, usually the default branch of the project
+	baseBranch string
 }
 
 // MergeTrainViewHelper provides helper functions for convert merge train to merge train views
@@ -18,19 +29,28 @@ type MergeTrainViewHelper interface {
 	// URL generators
 	BranchURL(projectID int, branchName string) string
 	CommitURL(projectID int, commitSHA string) string
+	CompareURL(projectID int, from, to string) string
 
 	// Branch information
 	GetBranchLatestCommit(projectID int, branchName string) (*models.CommitView, error)
 	// MergeRequest information
 	GetMergeRequestInfo(projectID int, branchName string) (*models.MergeRequestView, error)
+	// Pipeline information, the latest pipeline of a commit or nil if there is none
+	GetPipeline(projectID int, commitSHA string) (*models.PipelineView, error)
 
 	// Save merge train view to storage
 	Save(*models.MergeTrainView) error
 }
 
-// LoadMergeTrainOperator loads or creates a merge train operator
-func LoadMergeTrainOperator(repo *git.Repo, branchName string, projectID, issueIID int) (*MergeTrainOperator, error) {
-	commit, err := repo.RevParse(branchName)
+// LoadMergeTrainOperator loads or creates a merge train operator.
+//
+// The state is loaded from the local bb branch, or from the remote one if the clone doesn't have it,
+// like a fresh clone.
+func LoadMergeTrainOperator(ctx context.Context, repo Repository, branchName string, projectID, issueIID int) (*MergeTrainOperator, error) {
+	commit, err := repo.RevParse(ctx, branchName)
+	if err != nil {
+		commit, err = repo.RevParse(ctx, "refs/remotes/origin/"+branchName)
+	}
 	if err != nil {
 		// If branch doesn't exist, create a new merge train
 		return &MergeTrainOperator{
@@ -45,7 +65,7 @@ func LoadMergeTrainOperator(repo *git.Repo, branchName string, projectID, issueI
 	}
 
 	// Get commit message
-	message, err := repo.GetCommitMessage(commit)
+	message, err := repo.GetCommitMessage(ctx, commit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get commit message: %w", err)
 	}
@@ -62,81 +82,170 @@ func LoadMergeTrainOperator(repo *git.Repo, branchName string, projectID, issueI
 	}, nil
 }
 
+// SetValidations sets the commands that must pass against the merge result before a branch is added
+// or a conflict resolution is registered, all commands of one validation share the timeout
+func (o *MergeTrainOperator) SetValidations(commands []string, timeout time.Duration) {
+	o.validations = commands
+	o.validationTimeout = timeout
+}
+
+// SetBaseBranch sets the branch members are compared with in the view, which must have been fetched
+func (o *MergeTrainOperator) SetBaseBranch(branch string) {
+	o.baseBranch = branch
+}
+
+// Fetch fetches the bb branch, the branches of all members, the commits of resolutions missing
+// in the clone, e.g. a fresh clone, and the given refs from the remote, so that commands don't need
+// to fetch every branch of the repository
+func (o *MergeTrainOperator) Fetch(ctx context.Context, refs ...string) error {
+	refs = append(refs, "refs/heads/"+o.mergeTrain.BranchName)
+	for _, member := range o.mergeTrain.Members {
+		refs = append(refs, "refs/heads/"+member.Branch)
+	}
+	for _, res := range o.mergeTrain.Resolutions {
+		if _, err := o.repo.RevParse(ctx, res.Commit+"^{commit}"); err != nil {
+			refs = append(refs, res.Commit)
+		}
+	}
+	return o.repo.Fetch(ctx, refs...)
+}
+
 // AddAndPush adds a branch to the merge train and pushes the changes
-func (o *MergeTrainOperator) AddAndPush(ref *models.GitRef) (*models.GitRef, error) {
+func (o *MergeTrainOperator) AddAndPush(ctx context.Context, ref *models.GitRef, after ...string) (*models.GitMergeResult, error) {
 	// Add the branch to the merge train
-	mergeResult, fail := o.Add(ref)
+	mergeResult, fail := o.Add(ctx, ref, after...)
 	if fail != nil {
+		var mergeFail *models.GitMergeFailResult
+		if errors.As(fail, &mergeFail) {
+			// Pushing the helper branch is best effort, the merge failure is what matters
+			if branch, err := o.pushResolveBranch(ctx, ref); err == nil {
+				mergeFail.ResolveBranch = branch
+				mergeFail.ResolveMember = ref.Name
+			}
+		}
 		return nil, fail
 	}
 
 	// Push the changes
-	err := o.repo.PushRemote("origin", o.mergeTrain.BranchName, mergeResult.Commit)
+	err := o.push(ctx, mergeResult.Commit)
 	if err != nil {
 		return nil, err
 	}
 
+	o.deleteResolveBranches(ctx, ref.Name)
 	return mergeResult, nil
 }
 
-// Add adds or updates a branch in the merge train
-func (o *MergeTrainOperator) Add(ref *models.GitRef) (*models.GitRef, error) {
+// Add adds or updates a branch in the merge train.
+//
+// The branch is merged after the members in after, which it builds on. If after is empty,
+// an updated member keeps its existing dependencies.
+func (o *MergeTrainOperator) Add(ctx context.Context, ref *models.GitRef, after ...string) (*models.GitMergeResult, error) {
+	for _, dep := range after {
+		if dep == ref.Name {
+			return nil, fmt.Errorf("branch %s can't depend on itself", ref.Name)
+		}
+	}
+
 	// Create a copy of current members
 	currentMembers := make([]models.MergeTrainItem, len(o.mergeTrain.Members))
 	copy(currentMembers, o.mergeTrain.Members)
 
 	// Remove the branch if it's already in the merge train
+	dependsOn := after
 	for i, member := range currentMembers {
 		if member.Branch == ref.Name {
+			if len(after) == 0 {
+				dependsOn = member.DependsOn
+			}
 			// Remove this member
 			currentMembers = append(currentMembers[:i], currentMembers[i+1:]...)
 			break
 		}
 	}
-	newMembers := append(currentMembers, models.MergeTrainItem{
+	members, err := models.SortMembers(append(currentMembers, models.MergeTrainItem{
 		ProjectID:    o.mergeTrain.ProjectID,
 		Branch:       ref.Name,
 		MergedCommit: ref.Commit,
-	})
-
-	// Prepare refs for merge
-	refs := make([]*models.GitRef, 0, len(currentMembers)+1)
-	// Add existing members
-	for _, member := range currentMembers {
-		refs = append(refs, &models.GitRef{
-			Name:   member.Branch,
-			Commit: member.MergedCommit,
-		})
+		DependsOn:    dependsOn,
+	}))
+	if err != nil {
+		return nil, err
 	}
 
-	// Add the new branch
-	refs = append(refs, ref)
-
-	// Generate commit message before merge
-	message := o.mergeTrain.GenerateCommitMessageWithNewMemberSet(newMembers)
+	next := *o.mergeTrain
+	next.Members = members
+	return o.rebuild(ctx, &next, true)
+}
 
-	// Try to merge all branches with the generated message
-	mergeResult, mergeErr := o.repo.Merge(message, refs[0], refs[1:]...)
-	if mergeErr != nil {
-		return nil, mergeErr
+// push updates the remote bb branch to commit, or deletes it if commit is empty.
+//
+// Merge commits are deterministic, so rebuilding the same member set gives the same commit,
+// in which case the push is skipped to avoid triggering pipelines again.
+func (o *MergeTrainOperator) push(ctx context.Context, commit string) error {
+	remoteCommit, err := o.repo.RevParse(ctx, "refs/remotes/origin/"+o.mergeTrain.BranchName)
+	if err != nil {
+		remoteCommit = ""
 	}
+	if remoteCommit == commit {
+		return nil
+	}
+	return o.repo.PushRemote(ctx, "origin", o.mergeTrain.BranchName, commit)
+}
 
-	// Only update merge train state if merge was successful
-	o.mergeTrain.Members = newMembers
+// ResolveBranchName returns the name of the branch prepared for resolving conflicts with the given branch
+func (o *MergeTrainOperator) ResolveBranchName(branchName string) string {
+	return fmt.Sprintf("bb-resolve/%d/%s", o.mergeTrain.IssueIID, branchName)
+}
 
-	// Create or update the bb branch
-	err := o.repo.EnsureBranch(o.mergeTrain.BranchName, mergeResult.Commit)
+// pushResolveBranch merges all members except ref and pushes the result as a helper branch,
+// in which developers can merge ref and resolve the conflicts locally.
+func (o *MergeTrainOperator) pushResolveBranch(ctx context.Context, ref *models.GitRef) (string, error) {
+	next := *o.mergeTrain
+	next.Members = make([]models.MergeTrainItem, 0, len(o.mergeTrain.Members))
+	for _, member := range o.mergeTrain.Members {
+		if member.Branch != ref.Name {
+			next.Members = append(next.Members, member)
+		}
+	}
+	if len(next.Members) == 0 {
+		return "", fmt.Errorf("no other members to resolve conflicts with")
+	}
+
+	refs, err := o.mergeRefs(ctx, &next)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	message := fmt.Sprintf("Merge members of %s for resolving conflicts with %s", o.mergeTrain.BranchName, ref.Name)
+	mergeResult, err := o.repo.Merge(ctx, message, refs[0], refs[1:]...)
+	if err != nil {
+		return "", err
 	}
 
-	return mergeResult, nil
+	branchName := o.ResolveBranchName(ref.Name)
+	if err := o.repo.PushRemote(ctx, "origin", branchName, mergeResult.Commit); err != nil {
+		return "", err
+	}
+	return branchName, nil
+}
+
+// deleteResolveBranches deletes the resolve branches pushed for the given branches, which are stale once
+// the branches are added or removed. Only branches known to the clone are deleted, failures are ignored.
+func (o *MergeTrainOperator) deleteResolveBranches(ctx context.Context, branches ...string) {
+	for _, branch := range branches {
+		branchName := o.ResolveBranchName(branch)
+		if _, err := o.repo.RevParse(ctx, "refs/remotes/origin/"+branchName); err != nil {
+			continue
+		}
+		_ = o.repo.PushRemote(ctx, "origin", branchName, "")
+	}
 }
 
 // RemoveAndPush removes a branch from the merge train and pushes the changes
-func (o *MergeTrainOperator) RemoveAndPush(branchName string) (*models.GitRef, error) {
+func (o *MergeTrainOperator) RemoveAndPush(ctx context.Context, branchName string, cascade bool) (*models.GitMergeResult, error) {
 	// Remove the branch from the merge train
-	mergeResult, fail := o.Remove(branchName)
+	before := o.mergeTrain.Members
+	mergeResult, fail := o.Remove(ctx, branchName, cascade)
 	if fail != nil {
 		return nil, fail
 	}
@@ -150,16 +259,23 @@ func (o *MergeTrainOperator) RemoveAndPush(branchName string) (*models.GitRef, e
 	}
 
 	// Push the changes
-	err := o.repo.PushRemote("origin", o.mergeTrain.BranchName, pushCommit)
+	err := o.push(ctx, pushCommit)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, member := range before {
+		if !slices.ContainsFunc(o.mergeTrain.Members, func(m models.MergeTrainItem) bool { return m.Branch == member.Branch }) {
+			o.deleteResolveBranches(ctx, member.Branch)
+		}
+	}
 	return mergeResult, nil
 }
 
-// Remove removes a branch from the merge train and updates the bb branch
-func (o *MergeTrainOperator) Remove(branchName string) (*models.GitRef, error) {
+// Remove removes a branch from the merge train and updates the bb branch.
+//
+// Members depending on the branch are removed as well if cascade is true, otherwise the removal is refused.
+func (o *MergeTrainOperator) Remove(ctx context.Context, branchName string, cascade bool) (*models.GitMergeResult, error) {
 	// Check if branch exists in merge train
 	var branchIndex = -1
 	for i, member := range o.mergeTrain.Members {
@@ -172,44 +288,128 @@ func (o *MergeTrainOperator) Remove(branchName string) (*models.GitRef, error) {
 		return nil, fmt.Errorf("branch %s is not a member of merge train", branchName)
 	}
 
-	// Create a copy of current members without the branch to remove
-	currentMembers := make([]models.MergeTrainItem, 0, len(o.mergeTrain.Members)-1)
-	currentMembers = append(currentMembers, o.mergeTrain.Members[:branchIndex]...)
-	currentMembers = append(currentMembers, o.mergeTrain.Members[branchIndex+1:]...)
+	removing := []string{branchName}
+	if dependents := models.Dependents(o.mergeTrain.Members, branchName); len(dependents) > 0 {
+		if !cascade {
+			return nil, fmt.Errorf("branch %s is required by %s, remove them first or remove with --cascade",
+				branchName, strings.Join(dependents, ", "))
+		}
+		removing = append(removing, dependents...)
+	}
+
+	// Create a copy of current members without the branches to remove
+	next := *o.mergeTrain
+	next.Members = make([]models.MergeTrainItem, 0, len(o.mergeTrain.Members))
+	for _, member := range o.mergeTrain.Members {
+		if !slices.Contains(removing, member.Branch) {
+			next.Members = append(next.Members, member)
+		}
+	}
 
 	// If no members left after removal, remove local result branch and return nil
-	if len(currentMembers) == 0 {
-		err := o.repo.EnsureBranch(o.mergeTrain.BranchName, "")
+	if len(next.Members) == 0 {
+		err := o.repo.EnsureBranch(ctx, o.mergeTrain.BranchName, "")
 		if err != nil {
 			return nil, err
 		}
-		o.mergeTrain.Members = currentMembers
+		o.mergeTrain.Members = next.Members
+		o.mergeTrain.Resolutions = nil
+		o.mergeTrain.AutoResolved = nil
 		return nil, nil
 	}
 
+	for _, branch := range removing {
+		next.Resolutions = next.ResolutionsWithout(branch)
+	}
+	// Removal is never blocked by validations, any branch can leave the merge train at any time
+	return o.rebuild(ctx, &next, false)
+}
+
+// ResolveAndPush registers a conflict resolution and pushes the changes
+func (o *MergeTrainOperator) ResolveAndPush(ctx context.Context, branch1, branch2, commit string) (*models.GitMergeResult, error) {
+	mergeResult, fail := o.Resolve(ctx, branch1, branch2, commit)
+	if fail != nil {
+		return nil, fail
+	}
+
+	// Push the changes
+	err := o.push(ctx, mergeResult.Commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeResult, nil
+}
+
+// Resolve registers commit as the resolution of conflicts between two branches and rebuilds the bb branch.
+//
+// The branches don't need to be members yet, so that a conflict can be resolved before adding the branch
+// that caused it.
+func (o *MergeTrainOperator) Resolve(ctx context.Context, branch1, branch2, commit string) (*models.GitMergeResult, error) {
+	if branch1 == branch2 {
+		return nil, fmt.Errorf("can't resolve conflicts of branch %s with itself", branch1)
+	}
+	if len(o.mergeTrain.Members) == 0 {
+		return nil, fmt.Errorf("merge train is empty")
+	}
+	for _, branch := range []string{branch1, branch2} {
+		if err := git.CheckBranchName(branch); err != nil {
+			return nil, err
+		}
+	}
+	commitHash, err := o.repo.RevParse(ctx, commit+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("commit %s not found: %w", commit, err)
+	}
+
+	next := *o.mergeTrain
+	next.Resolutions = o.mergeTrain.ResolutionsWith(models.ConflictResolution{
+		Branches: [2]string{branch1, branch2},
+		Commit:   commitHash,
+	})
+	return o.rebuild(ctx, &next, true)
+}
+
+// rebuild merges the members of next merge train and updates the bb branch,
+// the merge train is only replaced with next if the merge succeeds and passes validations if validate is true.
+func (o *MergeTrainOperator) rebuild(ctx context.Context, next *models.MergeTrain, validate bool) (*models.GitMergeResult, error) {
 	// Prepare refs for merge
-	refs := make([]*models.GitRef, 0, len(currentMembers))
-	for _, member := range currentMembers {
-		refs = append(refs, &models.GitRef{
-			Name:   member.Branch,
-			Commit: member.MergedCommit,
-		})
+	refs, err := o.mergeRefs(ctx, next)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate commit message
-	message := o.mergeTrain.GenerateCommitMessageWithNewMemberSet(currentMembers)
+	// Generate commit message before merge
+	next.AutoResolved = nil
+	message := next.GenerateCommitMessage()
 
-	// Try to merge remaining branches
-	mergeResult, mergeErr := o.repo.Merge(message, refs[0], refs[1:]...)
+	// Try to merge all branches with the generated message
+	mergeResult, mergeErr := o.repo.Merge(ctx, message, refs[0], refs[1:]...)
 	if mergeErr != nil {
 		return nil, mergeErr
 	}
 
-	// Update merge train state
-	o.mergeTrain.Members = currentMembers
+	// Record conflicts resolved by merge strategies in the state
+	if len(mergeResult.AutoResolved) > 0 {
+		next.AutoResolved = mergeResult.AutoResolved
+		mergeResult.Commit, err = o.repo.RewordCommit(ctx, mergeResult.Commit, next.GenerateCommitMessage())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Reject the merge result if it breaks validations
+	if validate && len(o.validations) > 0 {
+		if err := o.repo.Validate(ctx, mergeResult.Commit, o.validations, o.validationTimeout); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only update merge train state if merge was successful
+	o.mergeTrain = next
 
-	// Update the bb branch
-	err := o.repo.EnsureBranch(o.mergeTrain.BranchName, mergeResult.Commit)
+	// Create or update the bb branch
+	err = o.repo.EnsureBranch(ctx, o.mergeTrain.BranchName, mergeResult.Commit)
 	if err != nil {
 		return nil, err
 	}
@@ -217,9 +417,73 @@ func (o *MergeTrainOperator) Remove(branchName string) (*models.GitRef, error) {
 	return mergeResult, nil
 }
 
+// mergeRefs returns the refs to merge for the members of merge train in order.
+//
+// A member is replaced by the commits of conflict resolutions between it and earlier members,
+// as long as the resolution commit contains the merged commits of both members.
+func (o *MergeTrainOperator) mergeRefs(ctx context.Context, mt *models.MergeTrain) ([]*models.GitRef, error) {
+	position := make(map[string]int, len(mt.Members))
+	for i, member := range mt.Members {
+		position[member.Branch] = i
+	}
+
+	refs := make([]*models.GitRef, 0, len(mt.Members))
+	for i, member := range mt.Members {
+		replaced := false
+		for _, res := range mt.Resolutions {
+			if !res.Involves(member.Branch) {
+				continue
+			}
+			other := res.Branches[0]
+			if other == member.Branch {
+				other = res.Branches[1]
+			}
+			if j, ok := position[other]; !ok || j >= i {
+				continue
+			}
+			upToDate, err := o.isResolutionUpToDate(ctx, mt, res)
+			if err != nil {
+				return nil, err
+			}
+			if upToDate {
+				refs = append(refs, &models.GitRef{
+					Name:   fmt.Sprintf("%s (resolved with %s)", member.Branch, other),
+					Commit: res.Commit,
+				})
+				replaced = true
+			}
+		}
+		if !replaced {
+			refs = append(refs, &models.GitRef{
+				Name:   member.Branch,
+				Commit: member.MergedCommit,
+			})
+		}
+	}
+	return refs, nil
+}
+
+// isResolutionUpToDate checks whether the resolution commit contains the merged commits of both branches.
+// A resolution commit missing in the clone, e.g. deleted from the remote, is outdated.
+func (o *MergeTrainOperator) isResolutionUpToDate(ctx context.Context, mt *models.MergeTrain, res models.ConflictResolution) (bool, error) {
+	if _, err := o.repo.RevParse(ctx, res.Commit+"^{commit}"); err != nil {
+		return false, nil
+	}
+	for _, member := range mt.Members {
+		if !res.Involves(member.Branch) {
+			continue
+		}
+		contained, err := o.repo.IsAncestor(ctx, member.MergedCommit, res.Commit)
+		if err != nil || !contained {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // SyncMergeTrainView synchronizes the merge train view with the actual state
-func (o *MergeTrainOperator) SyncMergeTrainView(helper MergeTrainViewHelper) error {
-	view, err := o.getMergeTrainView(helper)
+func (o *MergeTrainOperator) SyncMergeTrainView(ctx context.Context, helper MergeTrainViewHelper) error {
+	view, err := o.getMergeTrainView(ctx, helper)
 	if err != nil {
 		return err
 	}
@@ -228,19 +492,19 @@ func (o *MergeTrainOperator) SyncMergeTrainView(helper MergeTrainViewHelper) err
 }
 
 // getMergeTrainView returns a view of the merge train
-func (o *MergeTrainOperator) getMergeTrainView(helper MergeTrainViewHelper) (*models.MergeTrainView, error) {
+func (o *MergeTrainOperator) getMergeTrainView(ctx context.Context, helper MergeTrainViewHelper) (*models.MergeTrainView, error) {
 	mt, repo := o.mergeTrain, o.repo
 	view := &models.MergeTrainView{
 		Branch:  mt.BranchName,
 		URL:     helper.BranchURL(mt.ProjectID, mt.BranchName),
-		Members: make([]models.MemberView, 0, len(mt.Members)),
+		Members: make([]models.MemberView, len(mt.Members)),
 	}
 	if len(mt.Members) == 0 {
 		return view, nil
 	}
 
 	// Get bb branch latest commit
-	trainCommit, err := repo.RevParse(mt.BranchName)
+	trainCommit, err := repo.RevParse(ctx, mt.BranchName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get bb branch commit: %w", err)
 	}
@@ -248,36 +512,276 @@ func (o *MergeTrainOperator) getMergeTrainView(helper MergeTrainViewHelper) (*mo
 		SHA: trainCommit,
 		URL: helper.CommitURL(mt.ProjectID, trainCommit),
 	}
+	// Pipelines are informative, failing to look them up doesn't fail the view
+	if pipeline, err := helper.GetPipeline(mt.ProjectID, trainCommit); err == nil {
+		view.Pipeline = pipeline
+	}
 
-	// Convert members
-	for _, member := range mt.Members {
-		memberView := models.MemberView{
-			Branch:    member.Branch,
-			BranchURL: helper.BranchURL(mt.ProjectID, member.Branch),
+	// Members are looked up concurrently, a failed lookup leaves its part of the member unknown
+	// with a warning instead of failing the view
+	sem := make(chan struct{}, viewLookupConcurrency)
+	var wg sync.WaitGroup
+	for i, member := range mt.Members {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			view.Members[i] = memberView(mt.ProjectID, member, helper)
+		}()
+	}
+	wg.Wait()
+
+	o.fillBaseDistance(ctx, view)
+	o.fillUpdates(ctx, view, helper)
+	view.AutoResolved = mt.AutoResolved
+	o.fillHotspots(ctx, view)
+
+	// Convert conflict resolutions
+	for _, res := range mt.Resolutions {
+		upToDate, err := o.isResolutionUpToDate(ctx, mt, res)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check resolution %s: %w", res.Commit, err)
 		}
+		view.Resolutions = append(view.Resolutions, models.ResolutionView{
+			Branches: res.Branches,
+			Commit: &models.CommitView{
+				SHA: res.Commit,
+				URL: helper.CommitURL(mt.ProjectID, res.Commit),
+			},
+			Outdated: !upToDate,
+		})
+	}
 
-		// Set merged commit info
-		if member.MergedCommit != "" {
-			memberView.MergedCommit = &models.CommitView{
-				SHA: member.MergedCommit,
-				URL: helper.CommitURL(mt.ProjectID, member.MergedCommit),
-			}
+	return view, nil
+}
+
+// viewLookupConcurrency bounds the lookups of members running at the same time when building the view
+const viewLookupConcurrency = 8
+
+// memberView looks up the display information of a member, failed lookups are shown as warnings
+func memberView(projectID int, member models.MergeTrainItem, helper MergeTrainViewHelper) models.MemberView {
+	view := models.MemberView{
+		Branch:    member.Branch,
+		BranchURL: helper.BranchURL(projectID, member.Branch),
+		DependsOn: member.DependsOn,
+	}
+	if member.MergedCommit != "" {
+		view.MergedCommit = &models.CommitView{
+			SHA: member.MergedCommit,
+			URL: helper.CommitURL(projectID, member.MergedCommit),
+		}
+		if pipeline, err := helper.GetPipeline(projectID, member.MergedCommit); err == nil {
+			view.Pipeline = pipeline
+		} else {
+			view.Warnings = append(view.Warnings, err.Error())
+		}
+	}
+	if latestCommit, err := helper.GetBranchLatestCommit(projectID, member.Branch); err == nil {
+		view.LatestCommit = latestCommit
+	} else {
+		view.Warnings = append(view.Warnings, err.Error())
+	}
+	if mr, err := helper.GetMergeRequestInfo(projectID, member.Branch); err == nil {
+		view.MergeRequest = mr
+	} else {
+		view.Warnings = append(view.Warnings, err.Error())
+	}
+	return view
+}
+
+// fillBaseDistance fills how far the merged commit of each member is from the base branch and how old it is,
+// failures are shown as warnings of the members
+func (o *MergeTrainOperator) fillBaseDistance(ctx context.Context, view *models.MergeTrainView) {
+	// a base branch missing on the remote, e.g. in an empty project, leaves the distances unknown
+	base := o.baseCommit(ctx)
+	if base != "" {
+		view.BaseBranch = o.baseBranch
+	}
+	now := time.Now()
+	for i := range view.Members {
+		member := &view.Members[i]
+		if member.MergedCommit == nil {
+			continue
+		}
+		if committed, err := o.repo.CommitTime(ctx, member.MergedCommit.SHA); err == nil {
+			member.Age = now.Sub(committed)
+		} else {
+			member.Warnings = append(member.Warnings, fmt.Sprintf("failed to get age of merged commit: %s", err))
+		}
+		if base == "" {
+			continue
+		}
+		ahead, behind, err := o.repo.AheadBehind(ctx, member.MergedCommit.SHA, base)
+		if err != nil {
+			member.Warnings = append(member.Warnings, fmt.Sprintf("failed to compare with %s: %s", o.baseBranch, err))
+			continue
+		}
+		member.Ahead, member.Behind = ahead, behind
+		// the base branch itself is the foundation of the merge train rather than a redundant member
+		member.MergedUpstream = ahead == 0 && member.Branch != o.baseBranch
+	}
+}
+
+// The diff of a member lists at most diffMaxCommits commits and diffMaxFiles files
+const (
+	diffMaxCommits = 20
+	diffMaxFiles   = 30
+)
+
+// MemberDiff summarizes what a member changed between its merged commit and head, a commit that must have
+// been fetched
+func (o *MergeTrainOperator) MemberDiff(ctx context.Context, helper MergeTrainViewHelper, branchName, head string) (*models.MemberDiffView, error) {
+	for _, member := range o.mergeTrain.Members {
+		if member.Branch == branchName {
+			return o.diff(ctx, helper, branchName, member.MergedCommit, head)
 		}
+	}
+	return nil, fmt.Errorf("branch %s is not a member of merge train", branchName)
+}
+
+// diff summarizes the changes of branch between two commits
+func (o *MergeTrainOperator) diff(ctx context.Context, helper MergeTrainViewHelper, branch, from, to string) (*models.MemberDiffView, error) {
+	projectID := o.mergeTrain.ProjectID
+	diff := &models.MemberDiffView{
+		Branch:     branch,
+		From:       &models.CommitView{SHA: from, URL: helper.CommitURL(projectID, from)},
+		To:         &models.CommitView{SHA: to, URL: helper.CommitURL(projectID, to)},
+		CompareURL: helper.CompareURL(projectID, from, to),
+	}
+	var err error
+	if diff.TotalCommits, _, err = o.repo.AheadBehind(ctx, to, from); err != nil {
+		return nil, fmt.Errorf("failed to count commits: %w", err)
+	}
+	commits, err := o.repo.Log(ctx, from, to, diffMaxCommits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, c := range commits {
+		diff.Commits = append(diff.Commits, models.CommitView{SHA: c.SHA, URL: helper.CommitURL(projectID, c.SHA), Title: c.Title})
+	}
+	stats, err := o.repo.DiffStat(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diffstat: %w", err)
+	}
+	diff.TotalFiles = len(stats)
+	for _, stat := range stats {
+		diff.Added += stat.Added
+		diff.Deleted += stat.Deleted
+	}
+	diff.Files = stats[:min(len(stats), diffMaxFiles)]
+	return diff, nil
+}
 
-		// Get latest commit
-		latestCommit, err := helper.GetBranchLatestCommit(mt.ProjectID, member.Branch)
+// fillUpdates fills the diff of outdated members whose latest commit has been fetched, failures are shown
+// as warnings of the members
+func (o *MergeTrainOperator) fillUpdates(ctx context.Context, view *models.MergeTrainView, helper MergeTrainViewHelper) {
+	for i := range view.Members {
+		member := &view.Members[i]
+		if member.MergedCommit == nil || member.LatestCommit == nil || member.LatestCommit.SHA == member.MergedCommit.SHA {
+			continue
+		}
+		// views refreshed without a command don't fetch members, their latest commits may not be there yet
+		if _, err := o.repo.RevParse(ctx, member.LatestCommit.SHA+"^{commit}"); err != nil {
+			continue
+		}
+		updates, err := o.diff(ctx, helper, member.Branch, member.MergedCommit.SHA, member.LatestCommit.SHA)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get latest commit for branch %s: %w", member.Branch, err)
+			member.Warnings = append(member.Warnings, fmt.Sprintf("failed to summarize updates: %s", err))
+			continue
+		}
+		member.Updates = updates
+	}
+}
+
+// baseCommit returns the commit of the base branch on the remote, empty if there is no base branch or
+// it's missing on the remote
+func (o *MergeTrainOperator) baseCommit(ctx context.Context) string {
+	if o.baseBranch == "" {
+		return ""
+	}
+	commit, err := o.repo.RevParse(ctx, "refs/remotes/origin/"+o.baseBranch)
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+// membersMergeBase returns the best common ancestor of the merged commits of all members
+func (o *MergeTrainOperator) membersMergeBase(ctx context.Context) (string, error) {
+	members := o.mergeTrain.Members
+	base := members[0].MergedCommit
+	for _, member := range members[1:] {
+		var err error
+		if base, err = o.repo.MergeBase(ctx, base, member.MergedCommit); err != nil {
+			return "", err
 		}
-		memberView.LatestCommit = latestCommit
+	}
+	return base, nil
+}
 
-		// Get merge request info if exists
-		if mr, err := helper.GetMergeRequestInfo(mt.ProjectID, member.Branch); err == nil {
-			memberView.MergeRequest = mr
+// fillHotspots fills the diffstat of members, and the files modified by more than one member, failures are
+// shown as warnings of the members. Members are diffed since they forked from the base branch and the members
+// they depend on, so that neither upstream changes they brought in nor the changes of the members they build on
+// count as their own. Without a base branch, the merge base of all members stands in for it.
+func (o *MergeTrainOperator) fillHotspots(ctx context.Context, view *models.MergeTrainView) {
+	members := o.mergeTrain.Members
+	if len(members) < 2 {
+		return
+	}
+	base := o.baseCommit(ctx)
+	mergedCommits := make(map[string]string, len(members))
+	for _, member := range members {
+		mergedCommits[member.Branch] = member.MergedCommit
+	}
+	if base == "" {
+		var err error
+		if base, err = o.membersMergeBase(ctx); err != nil {
+			for i := range view.Members {
+				view.Members[i].Warnings = append(view.Members[i].Warnings, fmt.Sprintf("failed to find hotspots: %s", err))
+			}
+			return
 		}
+	}
 
-		view.Members = append(view.Members, memberView)
+	changes := make(map[string][]models.HotspotChange)
+	for i, member := range members {
+		forks := []string{base}
+		for _, dependency := range member.DependsOn {
+			if commit, ok := mergedCommits[dependency]; ok {
+				forks = append(forks, commit)
+			}
+		}
+		forkPoint, err := o.repo.MergeBase(ctx, member.MergedCommit, forks...)
+		if err != nil {
+			view.Members[i].Warnings = append(view.Members[i].Warnings, fmt.Sprintf("failed to find hotspots: %s", err))
+			continue
+		}
+		stats, err := o.repo.DiffStat(ctx, forkPoint, member.MergedCommit)
+		if err != nil {
+			view.Members[i].Warnings = append(view.Members[i].Warnings, fmt.Sprintf("failed to find hotspots: %s", err))
+			continue
+		}
+		diffStat := &models.DiffStatView{Files: len(stats)}
+		for _, stat := range stats {
+			diffStat.Added += stat.Added
+			diffStat.Deleted += stat.Deleted
+			changes[stat.Path] = append(changes[stat.Path], models.HotspotChange{
+				Branch:  member.Branch,
+				Added:   stat.Added,
+				Deleted: stat.Deleted,
+				Binary:  stat.Binary,
+			})
+		}
+		view.Members[i].DiffStat = diffStat
 	}
 
-	return view, nil
+	for path, pathChanges := range changes {
+		if len(pathChanges) > 1 {
+			view.Hotspots = append(view.Hotspots, models.HotspotView{Path: path, Changes: pathChanges})
+		}
+	}
+	slices.SortFunc(view.Hotspots, func(a, b models.HotspotView) int {
+		return strings.Compare(a.Path, b.Path)
+	})
 }
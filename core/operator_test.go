@@ -1,7 +1,13 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/jizhilong/branch-bot/git"
 	"github.com/jizhilong/branch-bot/models"
@@ -10,6 +16,7 @@ import (
 )
 
 func TestMergeTrainOperator_Add(t *testing.T) {
+	ctx := context.Background()
 	testRepo := git.NewTestRepo(t)
 
 	operator := &MergeTrainOperator{
@@ -23,14 +30,14 @@ func TestMergeTrainOperator_Add(t *testing.T) {
 	}
 
 	// Get base commit
-	baseHash, err := testRepo.RevParse("HEAD")
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
 	require.NoError(t, err)
 	base := &models.GitRef{Name: "main", Commit: baseHash}
 
 	t.Run("add first branch", func(t *testing.T) {
 		// Create and add first branch
 		feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
-		result, fail := operator.Add(feature1)
+		result, fail := operator.Add(ctx, feature1)
 		assert.NotNil(t, result)
 		assert.Nil(t, fail)
 		assert.Len(t, operator.mergeTrain.Members, 1)
@@ -40,7 +47,7 @@ func TestMergeTrainOperator_Add(t *testing.T) {
 	t.Run("add second branch without conflict", func(t *testing.T) {
 		// Create and add second branch
 		feature2 := testRepo.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
-		result, fail := operator.Add(feature2)
+		result, fail := operator.Add(ctx, feature2)
 		assert.NotNil(t, result)
 		assert.Nil(t, fail)
 		assert.Len(t, operator.mergeTrain.Members, 2)
@@ -50,7 +57,7 @@ func TestMergeTrainOperator_Add(t *testing.T) {
 	t.Run("update existing branch", func(t *testing.T) {
 		// Update feature1 with new content
 		feature1 := testRepo.UpdateBranch("feature1", "file1.txt", "updated content")
-		result, fail := operator.Add(feature1)
+		result, fail := operator.Add(ctx, feature1)
 		assert.NotNil(t, result)
 		assert.Nil(t, fail)
 		assert.Len(t, operator.mergeTrain.Members, 2)
@@ -61,7 +68,7 @@ func TestMergeTrainOperator_Add(t *testing.T) {
 	t.Run("add branch with conflict", func(t *testing.T) {
 		// Create a branch that conflicts with feature1
 		conflict := testRepo.CreateBranch(base, "conflict", "file1.txt", "conflicting content")
-		result, fail := operator.Add(conflict)
+		result, fail := operator.Add(ctx, conflict)
 		assert.Nil(t, result)
 		assert.NotNil(t, fail)
 		// MergeTrain should remain unchanged
@@ -74,6 +81,7 @@ func TestMergeTrainOperator_Add(t *testing.T) {
 }
 
 func TestMergeTrainOperator_Remove(t *testing.T) {
+	ctx := context.Background()
 	testRepo := git.NewTestRepo(t)
 
 	// Create initial merge train with three branches
@@ -88,7 +96,7 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	}
 
 	// Get base commit
-	baseHash, err := testRepo.RevParse("HEAD")
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
 	require.NoError(t, err)
 	base := &models.GitRef{Name: "main", Commit: baseHash}
 
@@ -98,18 +106,18 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	feature3 := testRepo.CreateBranch(base, "feature3", "file3.txt", "feature3 content")
 
 	// Add branches to merge train
-	result, fail := operator.Add(feature1)
+	result, fail := operator.Add(ctx, feature1)
 	require.NotNil(t, result)
 	require.Nil(t, fail)
-	result, fail = operator.Add(feature2)
+	result, fail = operator.Add(ctx, feature2)
 	require.NotNil(t, result)
 	require.Nil(t, fail)
-	result, fail = operator.Add(feature3)
+	result, fail = operator.Add(ctx, feature3)
 	require.NotNil(t, result)
 	require.Nil(t, fail)
 
 	t.Run("remove non-existent branch", func(t *testing.T) {
-		result, fail := operator.Remove("non-existent")
+		result, fail := operator.Remove(ctx, "non-existent", false)
 		assert.Nil(t, result)
 		assert.NotNil(t, fail)
 		// MergeTrain should remain unchanged
@@ -117,7 +125,7 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	})
 
 	t.Run("remove middle branch", func(t *testing.T) {
-		result, fail := operator.Remove("feature2")
+		result, fail := operator.Remove(ctx, "feature2", false)
 		assert.NotNil(t, result)
 		assert.Nil(t, fail)
 		// Check remaining members
@@ -127,7 +135,7 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	})
 
 	t.Run("remove first branch", func(t *testing.T) {
-		result, fail := operator.Remove("feature1")
+		result, fail := operator.Remove(ctx, "feature1", false)
 		assert.NotNil(t, result)
 		assert.Nil(t, fail)
 		// Check remaining members
@@ -136,7 +144,7 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	})
 
 	t.Run("remove last branch", func(t *testing.T) {
-		result, fail := operator.Remove("feature3")
+		result, fail := operator.Remove(ctx, "feature3", false)
 		assert.Nil(t, result)
 		assert.Nil(t, fail)
 		// Check members are empty
@@ -144,7 +152,7 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 	})
 
 	t.Run("remove from empty train", func(t *testing.T) {
-		result, fail := operator.Remove("feature1")
+		result, fail := operator.Remove(ctx, "feature1", false)
 		assert.Nil(t, result)
 		assert.NotNil(t, fail)
 		// MergeTrain should remain empty
@@ -153,16 +161,17 @@ func TestMergeTrainOperator_Remove(t *testing.T) {
 }
 
 func TestLoadMergeTrainOperator(t *testing.T) {
+	ctx := context.Background()
 	testRepo := git.NewTestRepo(t)
 	repo := &testRepo.Repo
 	// Get base commit
-	baseHash, err := testRepo.RevParse("HEAD")
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
 	require.NoError(t, err)
 	base := &models.GitRef{Name: "main", Commit: baseHash}
 	branchName := "bb-branches/456"
 
 	t.Run("load non-existent merge train", func(t *testing.T) {
-		operator, err := LoadMergeTrainOperator(repo, branchName, 123, 456)
+		operator, err := LoadMergeTrainOperator(ctx, repo, branchName, 123, 456)
 		require.NoError(t, err)
 		assert.NotNil(t, operator)
 		assert.Equal(t, 123, operator.mergeTrain.ProjectID)
@@ -183,11 +192,11 @@ func TestLoadMergeTrainOperator(t *testing.T) {
 			},
 		}
 		addAndCheckLoaded := func(ref *models.GitRef) {
-			result, fail := operator.Add(ref)
+			result, fail := operator.Add(ctx, ref)
 			require.NotNil(t, result)
 			require.Nil(t, fail)
 			// Load the merge train
-			loadedOperator, err := LoadMergeTrainOperator(repo, branchName, 123, 456)
+			loadedOperator, err := LoadMergeTrainOperator(ctx, repo, branchName, 123, 456)
 			require.NoError(t, err)
 			assert.NotNil(t, loadedOperator)
 			assert.Equal(t, operator.mergeTrain.ProjectID, loadedOperator.mergeTrain.ProjectID)
@@ -208,3 +217,773 @@ func TestLoadMergeTrainOperator(t *testing.T) {
 		addAndCheckLoaded(feature2)
 	})
 }
+
+func TestMergeTrainOperator_Resolve(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+
+	feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	feature2 := testRepo.CreateBranch(base, "feature2", "file1.txt", "feature2 content")
+	resolution := testRepo.ResolveConflict(feature1, feature2, "resolution", "file1.txt", "merged content")
+
+	t.Run("resolve on empty train", func(t *testing.T) {
+		result, fail := operator.Resolve(ctx, "feature1", "feature2", resolution.Commit)
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+	})
+
+	result, fail := operator.Add(ctx, feature1)
+	require.NotNil(t, result)
+	require.Nil(t, fail)
+
+	t.Run("add conflicting branch", func(t *testing.T) {
+		result, fail := operator.Add(ctx, feature2)
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+		assert.Len(t, operator.mergeTrain.Members, 1)
+	})
+
+	t.Run("resolve unknown commit", func(t *testing.T) {
+		result, fail := operator.Resolve(ctx, "feature1", "feature2", "0000000000000000000000000000000000000000")
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+		assert.Empty(t, operator.mergeTrain.Resolutions)
+	})
+
+	t.Run("resolve malicious input", func(t *testing.T) {
+		result, fail := operator.Resolve(ctx, "feature1", "--upload-pack=touch /tmp/pwned", resolution.Commit)
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+		result, fail = operator.Resolve(ctx, "feature1", "feature2", "--output=/tmp/pwned")
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+		assert.Empty(t, operator.mergeTrain.Resolutions)
+	})
+
+	t.Run("add conflicting branch after resolving", func(t *testing.T) {
+		result, fail := operator.Resolve(ctx, "feature1", "feature2", resolution.Commit)
+		require.NotNil(t, result)
+		require.Nil(t, fail)
+		assert.Len(t, operator.mergeTrain.Resolutions, 1)
+
+		result, fail = operator.Add(ctx, feature2)
+		assert.NotNil(t, result)
+		assert.Nil(t, fail)
+		assert.Len(t, operator.mergeTrain.Members, 2)
+	})
+
+	t.Run("update branch outdates resolution", func(t *testing.T) {
+		feature2 := testRepo.UpdateBranch("feature2", "file1.txt", "updated feature2 content")
+		result, fail := operator.Add(ctx, feature2)
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+	})
+
+	t.Run("remove branch drops resolution", func(t *testing.T) {
+		result, fail := operator.Remove(ctx, "feature2", false)
+		assert.NotNil(t, result)
+		assert.Nil(t, fail)
+		assert.Empty(t, operator.mergeTrain.Resolutions)
+	})
+}
+
+func TestLoadMergeTrainOperator_evictedClone(t *testing.T) {
+	ctx := context.Background()
+	remote := git.NewTestRepo(t)
+	baseHash, err := remote.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+	feature1 := remote.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	feature2 := remote.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
+	branchName := "bb-branches/456"
+
+	manager := git.NewManager(t.TempDir(), git.ManagerOptions{IdleTimeout: time.Nanosecond, MaintenanceInterval: time.Hour})
+	acquire := func() *git.Repo {
+		repo, release, err := manager.Acquire(ctx, "group/project", remote.Path(), git.Options{})
+		require.NoError(t, err)
+		release()
+		return repo
+	}
+
+	repo := acquire()
+	operator, err := LoadMergeTrainOperator(ctx, repo, branchName, 123, 456)
+	require.NoError(t, err)
+	for _, ref := range []*models.GitRef{feature1, feature2} {
+		_, err := operator.AddAndPush(ctx, ref)
+		require.NoError(t, err)
+	}
+
+	manager.Maintain(ctx)
+	require.NoDirExists(t, repo.Path())
+
+	// the clone is cloned again, with the bb branch only under the remote refs
+	repo = acquire()
+	require.NoError(t, repo.Fetch(ctx, "refs/heads/"+branchName))
+	loaded, err := LoadMergeTrainOperator(ctx, repo, branchName, 123, 456)
+	require.NoError(t, err)
+	assert.Equal(t, operator.mergeTrain.Members, loaded.mergeTrain.Members)
+}
+
+func TestMergeTrainOperator_pushResolveBranch(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+	remoteRepo := git.NewTestRepo(t)
+	require.NoError(t, testRepo.EnsureRemote(ctx, "origin", remoteRepo.Path()))
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+
+	feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	feature2 := testRepo.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
+	conflict := testRepo.CreateBranch(base, "conflict", "file1.txt", "conflicting content")
+	_, err = operator.AddAndPush(ctx, feature1)
+	require.NoError(t, err)
+	_, err = operator.AddAndPush(ctx, feature2)
+	require.NoError(t, err)
+
+	result, fail := operator.AddAndPush(ctx, conflict)
+	assert.Nil(t, result)
+	if mergeFail, ok := fail.(*models.GitMergeFailResult); assert.True(t, ok) {
+		assert.Equal(t, "bb-resolve/456/conflict", mergeFail.ResolveBranch)
+		assert.Equal(t, "conflict", mergeFail.ResolveMember)
+		assert.Equal(t, []string{"feature1", "conflict"}, mergeFail.ConflictBranches)
+	}
+
+	// The resolve branch contains all other members
+	resolveCommit, err := remoteRepo.RevParse(ctx, "bb-resolve/456/conflict")
+	require.NoError(t, err)
+	for _, ref := range []*models.GitRef{feature1, feature2} {
+		contained, err := remoteRepo.IsAncestor(ctx, ref.Commit, resolveCommit)
+		require.NoError(t, err)
+		assert.True(t, contained)
+	}
+
+	// The resolve branch is deleted once the branch is added
+	_, err = operator.RemoveAndPush(ctx, "feature1", false)
+	require.NoError(t, err)
+	_, err = operator.AddAndPush(ctx, conflict)
+	require.NoError(t, err)
+	_, err = remoteRepo.RevParse(ctx, "bb-resolve/456/conflict")
+	assert.Error(t, err)
+
+	// or removed
+	feature2 = testRepo.UpdateBranch("feature2", "file1.txt", "feature2 conflicting content")
+	_, err = operator.AddAndPush(ctx, feature2)
+	require.Error(t, err)
+	_, err = remoteRepo.RevParse(ctx, "bb-resolve/456/feature2")
+	require.NoError(t, err)
+	_, err = operator.RemoveAndPush(ctx, "feature2", false)
+	require.NoError(t, err)
+	_, err = remoteRepo.RevParse(ctx, "bb-resolve/456/feature2")
+	assert.Error(t, err)
+}
+
+func TestMergeTrainOperator_fillHotspots(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+	shared := testRepo.CreateBranch(base, "shared", "shared.txt", "a\nb\nc\nd\ne\n")
+
+	// Both features modify shared.txt without conflicts
+	feature1 := testRepo.CreateBranch(shared, "feature1", "shared.txt", "a1\nb\nc\nd\ne\n")
+	feature2 := testRepo.CreateBranch(shared, "feature2", "shared.txt", "a\nb\nc\nd\ne2\n")
+	feature2 = testRepo.UpdateBranch("feature2", "file2.txt", "feature2 content\n")
+	for _, ref := range []*models.GitRef{shared, feature1, feature2} {
+		_, fail := operator.Add(ctx, ref)
+		require.Nil(t, fail)
+	}
+
+	view := &models.MergeTrainView{Members: make([]models.MemberView, len(operator.mergeTrain.Members))}
+	operator.fillHotspots(ctx, view)
+	if assert.Len(t, view.Hotspots, 1) {
+		assert.Equal(t, "shared.txt", view.Hotspots[0].Path)
+		assert.Equal(t, []models.HotspotChange{
+			{Branch: "feature1", Added: 1, Deleted: 1},
+			{Branch: "feature2", Added: 1, Deleted: 1},
+		}, view.Hotspots[0].Changes)
+	}
+	// shared is the merge base of all members, so it changes nothing
+	assert.Equal(t, &models.DiffStatView{}, view.Members[0].DiffStat)
+	assert.Equal(t, &models.DiffStatView{Files: 2, Added: 2, Deleted: 1}, view.Members[2].DiffStat)
+}
+
+func TestMergeTrainOperator_fillHotspotsForkPoints(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) (*git.TestRepo, *MergeTrainOperator, *models.GitRef) {
+		testRepo := git.NewTestRepo(t)
+		operator := &MergeTrainOperator{
+			repo:       &testRepo.Repo,
+			baseBranch: "main",
+			mergeTrain: &models.MergeTrain{ProjectID: 123, IssueIID: 456, BranchName: "bb-branches/456"},
+		}
+		baseHash, err := testRepo.RevParse(ctx, "HEAD")
+		require.NoError(t, err)
+		return testRepo, operator, &models.GitRef{Name: "main", Commit: baseHash}
+	}
+	// setRemoteBase points the base branch on the remote to commit
+	setRemoteBase := func(t *testing.T, testRepo *git.TestRepo, commit string) {
+		out, err := exec.Command("git", "-C", testRepo.Path(), "update-ref", "refs/remotes/origin/main", commit).CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	fill := func(operator *MergeTrainOperator) *models.MergeTrainView {
+		view := &models.MergeTrainView{Members: make([]models.MemberView, len(operator.mergeTrain.Members))}
+		operator.fillHotspots(ctx, view)
+		return view
+	}
+
+	t.Run("members forked from different base commits", func(t *testing.T) {
+		testRepo, operator, base := setup(t)
+		feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1\n")
+		upstream := testRepo.UpdateBranch("main", "upstream.txt", "upstream\n")
+		setRemoteBase(t, testRepo, upstream.Commit)
+		feature2 := testRepo.CreateBranch(upstream, "feature2", "file2.txt", "feature2\n")
+		feature3 := testRepo.CreateBranch(upstream, "feature3", "file3.txt", "feature3\n")
+		for _, ref := range []*models.GitRef{feature1, feature2, feature3} {
+			_, fail := operator.Add(ctx, ref)
+			require.Nil(t, fail)
+		}
+
+		view := fill(operator)
+		assert.Empty(t, view.Hotspots, "upstream changes are not changes of the members")
+		for _, member := range view.Members {
+			assert.Empty(t, member.Warnings)
+			assert.Equal(t, &models.DiffStatView{Files: 1, Added: 1}, member.DiffStat)
+		}
+	})
+
+	t.Run("stacked members", func(t *testing.T) {
+		testRepo, operator, base := setup(t)
+		setRemoteBase(t, testRepo, base.Commit)
+		stack := testRepo.CreateBranch(base, "stack", "stack.txt", "stack\n")
+		dependent := testRepo.CreateBranch(stack, "dependent", "dependent.txt", "dependent\n")
+		_, fail := operator.Add(ctx, stack)
+		require.Nil(t, fail)
+		_, fail = operator.Add(ctx, dependent, "stack")
+		require.Nil(t, fail)
+
+		view := fill(operator)
+		assert.Empty(t, view.Hotspots, "changes of the members built on are not changes of the dependents")
+		assert.Equal(t, &models.DiffStatView{Files: 1, Added: 1}, view.Members[0].DiffStat)
+		assert.Equal(t, &models.DiffStatView{Files: 1, Added: 1}, view.Members[1].DiffStat)
+	})
+}
+
+func TestMergeTrainOperator_Dependencies(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+
+	feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	stacked := testRepo.CreateBranch(feature1, "stacked", "stacked.txt", "stacked content")
+	feature2 := testRepo.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
+	branchNames := func() []string {
+		var names []string
+		for _, member := range operator.mergeTrain.Members {
+			names = append(names, member.Branch)
+		}
+		return names
+	}
+
+	t.Run("add after non-member", func(t *testing.T) {
+		result, fail := operator.Add(ctx, stacked, "feature1")
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+	})
+
+	t.Run("add stacked branch", func(t *testing.T) {
+		for _, ref := range []*models.GitRef{feature1, feature2} {
+			_, fail := operator.Add(ctx, ref)
+			require.Nil(t, fail)
+		}
+		result, fail := operator.Add(ctx, stacked, "feature1")
+		assert.NotNil(t, result)
+		assert.Nil(t, fail)
+		assert.Equal(t, []string{"feature1", "feature2", "stacked"}, branchNames())
+	})
+
+	t.Run("update base keeps dependents after it", func(t *testing.T) {
+		feature1 := testRepo.UpdateBranch("feature1", "file1.txt", "updated content")
+		result, fail := operator.Add(ctx, feature1)
+		assert.NotNil(t, result)
+		assert.Nil(t, fail)
+		assert.Equal(t, []string{"feature2", "feature1", "stacked"}, branchNames())
+	})
+
+	t.Run("circular dependency", func(t *testing.T) {
+		result, fail := operator.Add(ctx, feature1, "stacked")
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+	})
+
+	t.Run("remove base is refused", func(t *testing.T) {
+		result, fail := operator.Remove(ctx, "feature1", false)
+		assert.Nil(t, result)
+		assert.NotNil(t, fail)
+		assert.Len(t, operator.mergeTrain.Members, 3)
+	})
+
+	t.Run("remove base with cascade", func(t *testing.T) {
+		result, fail := operator.Remove(ctx, "feature1", true)
+		assert.NotNil(t, result)
+		assert.Nil(t, fail)
+		assert.Equal(t, []string{"feature2"}, branchNames())
+	})
+}
+
+func TestMergeTrainOperator_pushSkipsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+	remoteRepo := git.NewTestRepo(t)
+	require.NoError(t, testRepo.EnsureRemote(ctx, "origin", remoteRepo.Path()))
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+	feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	feature2 := testRepo.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
+	_, err = operator.AddAndPush(ctx, feature1)
+	require.NoError(t, err)
+	first, err := operator.AddAndPush(ctx, feature2)
+	require.NoError(t, err)
+
+	// Re-adding an unchanged branch gives the same commit, which must not be pushed again
+	require.NoError(t, testRepo.EnsureRemote(ctx, "origin", filepath.Join(t.TempDir(), "missing")))
+	second, err := operator.AddAndPush(ctx, feature2)
+	require.NoError(t, err)
+	assert.Equal(t, first.Commit, second.Commit)
+}
+
+func TestMergeTrainOperator_Validations(t *testing.T) {
+	ctx := context.Background()
+	testRepo := git.NewTestRepo(t)
+
+	operator := &MergeTrainOperator{
+		repo: &testRepo.Repo,
+		mergeTrain: &models.MergeTrain{
+			ProjectID:  123,
+			IssueIID:   456,
+			BranchName: "bb-branches/456",
+			Members:    make([]models.MergeTrainItem, 0),
+		},
+	}
+	operator.SetValidations([]string{"! git grep -nE '^(<<<<<<<|>>>>>>>) '"}, time.Minute)
+
+	// Get base commit
+	baseHash, err := testRepo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+
+	feature1 := testRepo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+	broken := testRepo.CreateBranch(base, "broken", "file2.txt", "<<<<<<< HEAD\nleftover\n>>>>>>> other\n")
+
+	_, fail := operator.Add(ctx, feature1)
+	require.Nil(t, fail)
+	before := *operator.mergeTrain
+
+	result, fail := operator.Add(ctx, broken)
+	assert.Nil(t, result)
+	var validationFail *models.ValidationFail
+	require.ErrorAs(t, fail, &validationFail)
+	assert.Contains(t, validationFail.Stdout, "file2.txt")
+	assert.Equal(t, before, *operator.mergeTrain)
+}
+
+func TestMergeTrainOperator_FakeRepository(t *testing.T) {
+	ctx := context.Background()
+	newOperator := func(repo Repository) *MergeTrainOperator {
+		return &MergeTrainOperator{
+			repo: repo,
+			mergeTrain: &models.MergeTrain{
+				ProjectID:  123,
+				IssueIID:   456,
+				BranchName: "bb-branches/456",
+				Members:    make([]models.MergeTrainItem, 0),
+			},
+		}
+	}
+
+	t.Run("resolve conflicts", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		feature1 := repo.branch("feature1", base, "feature1")
+		feature2 := repo.branch("feature2", base, "feature2")
+		repo.conflict(feature1.Commit, feature2.Commit)
+		operator := newOperator(repo)
+
+		_, fail := operator.AddAndPush(ctx, feature1)
+		require.NoError(t, fail)
+		_, fail = operator.AddAndPush(ctx, feature2)
+		var mergeFail *models.GitMergeFailResult
+		require.ErrorAs(t, fail, &mergeFail)
+		assert.Equal(t, []string{"feature1", "feature2"}, mergeFail.ConflictBranches)
+		assert.Equal(t, "bb-resolve/456/feature2", mergeFail.ResolveBranch)
+		assert.Equal(t, feature1.Commit, repo.remote["bb-resolve/456/feature2"])
+		assert.Len(t, operator.mergeTrain.Members, 1)
+		assert.Equal(t, feature1.Commit, repo.remote["bb-branches/456"])
+
+		// the developer merges feature2 into the resolve branch and pushes the resolution
+		resolution := repo.commit("resolve conflicts", feature1.Commit, feature2.Commit)
+		_, fail = operator.ResolveAndPush(ctx, "feature1", "feature2", resolution)
+		require.NoError(t, fail)
+		result, fail := operator.AddAndPush(ctx, feature2)
+		require.NoError(t, fail)
+		assert.Len(t, operator.mergeTrain.Members, 2)
+		assert.Equal(t, result.Commit, repo.remote["bb-branches/456"])
+		assert.NotContains(t, repo.remote, "bb-resolve/456/feature2")
+		assert.Equal(t, []string{feature1.Commit, resolution}, repo.commits[result.Commit].parents)
+
+		// a fresh clone doesn't have the resolution, which may not be on any branch
+		repo.unfetched[resolution] = repo.commits[resolution]
+		delete(repo.commits, resolution)
+		helper := &fakeViewHelper{}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.True(t, helper.saved.Resolutions[0].Outdated)
+		require.NoError(t, operator.Fetch(ctx))
+		assert.Contains(t, repo.fetched, resolution)
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.False(t, helper.saved.Resolutions[0].Outdated)
+	})
+
+	t.Run("push failure", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		_, fail := operator.AddAndPush(ctx, repo.branch("feature1", base, "feature1"))
+		require.NoError(t, fail)
+		pushed := repo.remote["bb-branches/456"]
+
+		repo.pushErr = errors.New("remote rejected")
+		_, fail = operator.AddAndPush(ctx, repo.branch("feature2", base, "feature2"))
+		assert.ErrorIs(t, fail, repo.pushErr)
+		assert.Equal(t, pushed, repo.remote["bb-branches/456"])
+
+		repo.pushErr = nil
+		result, fail := operator.RemoveAndPush(ctx, "feature2", false)
+		require.NoError(t, fail)
+		assert.Equal(t, pushed, result.Commit)
+	})
+
+	t.Run("skip pushing unchanged merge result", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		feature1 := repo.branch("feature1", base, "feature1")
+		operator := newOperator(repo)
+		_, fail := operator.AddAndPush(ctx, feature1)
+		require.NoError(t, fail)
+		_, fail = operator.AddAndPush(ctx, feature1)
+		require.NoError(t, fail)
+		assert.Equal(t, []string{"bb-branches/456"}, repo.pushed)
+	})
+
+	t.Run("reject failed validation", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		operator.SetValidations([]string{"make test"}, time.Minute)
+		repo.validateErr = errors.New("tests failed")
+		_, fail := operator.AddAndPush(ctx, repo.branch("feature1", base, "feature1"))
+		assert.ErrorIs(t, fail, repo.validateErr)
+		assert.Empty(t, operator.mergeTrain.Members)
+		assert.Empty(t, repo.pushed)
+	})
+
+	t.Run("view pipelines", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		feature1 := repo.branch("feature1", base, "feature1")
+		feature2 := repo.branch("feature2", base, "feature2")
+		for _, ref := range []*models.GitRef{feature1, feature2} {
+			_, fail := operator.AddAndPush(ctx, ref)
+			require.NoError(t, fail)
+		}
+		helper := &fakeViewHelper{pipelines: map[string]*models.PipelineView{
+			repo.branches["bb-branches/456"]: {Status: "running", URL: "https://gitlab.example.com/pipelines/2"},
+			feature1.Commit:                  {Status: "success", URL: "https://gitlab.example.com/pipelines/1"},
+		}}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.Equal(t, "running", helper.saved.Pipeline.Status)
+		assert.Equal(t, "success", helper.saved.Members[0].Pipeline.Status)
+		assert.Nil(t, helper.saved.Members[1].Pipeline)
+	})
+
+	t.Run("view degrades on failed lookups", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		branches := make([]string, 0, 2*viewLookupConcurrency)
+		for i := range cap(branches) {
+			name := fmt.Sprintf("feature%d", i)
+			_, fail := operator.AddAndPush(ctx, repo.branch(name, base, name))
+			require.NoError(t, fail)
+			branches = append(branches, name)
+		}
+		helper := &fakeViewHelper{lookupErrs: map[string]error{"feature3": errors.New("rate limited")}}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		require.Len(t, helper.saved.Members, len(branches))
+		for i, member := range helper.saved.Members {
+			// members keep their order though they are looked up concurrently
+			assert.Equal(t, branches[i], member.Branch)
+			if member.Branch == "feature3" {
+				assert.Nil(t, member.LatestCommit)
+				assert.Equal(t, []string{"rate limited", "rate limited"}, member.Warnings)
+			} else {
+				assert.Empty(t, member.Warnings)
+				assert.Equal(t, "latest-"+member.Branch, member.LatestCommit.SHA)
+			}
+		}
+	})
+
+	t.Run("view degrades on failed hotspots", func(t *testing.T) {
+		repo := newFakeRepository()
+		operator := newOperator(repo)
+		// members with unrelated histories have no merge base
+		for _, name := range []string{"feature1", "feature2"} {
+			_, fail := operator.AddAndPush(ctx, repo.branch(name, repo.commit("root of "+name), name))
+			require.NoError(t, fail)
+		}
+		helper := &fakeViewHelper{}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		require.Len(t, helper.saved.Members, 2)
+		for _, member := range helper.saved.Members {
+			if assert.Len(t, member.Warnings, 1) {
+				assert.Contains(t, member.Warnings[0], "failed to find hotspots")
+			}
+		}
+		assert.Empty(t, helper.saved.Hotspots)
+	})
+
+	t.Run("view base distance", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		main := repo.branch("main", base, "main")
+		feature := repo.branch("feature", base, "feature")
+		hotfix := repo.branch("hotfix", base, "hotfix")
+		old := repo.commits[feature.Commit]
+		old.time = time.Now().Add(-72 * time.Hour)
+		repo.commits[feature.Commit] = old
+		for _, ref := range []*models.GitRef{main, feature, hotfix} {
+			_, fail := operator.AddAndPush(ctx, ref)
+			require.NoError(t, fail)
+		}
+		// hotfix is merged upstream after joining the merge train
+		repo.remote["main"] = repo.commit("merge hotfix", main.Commit, hotfix.Commit)
+
+		helper := &fakeViewHelper{}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.Equal(t, "", helper.saved.BaseBranch, "no base branch is compared without setting it")
+		assert.Positive(t, helper.saved.Members[1].Age)
+
+		operator.SetBaseBranch("main")
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		view := helper.saved
+		assert.Equal(t, "main", view.BaseBranch)
+		members := make(map[string]models.MemberView)
+		for _, member := range view.Members {
+			members[member.Branch] = member
+		}
+		assert.Equal(t, [2]int{0, 2}, [2]int{members["main"].Ahead, members["main"].Behind})
+		assert.False(t, members["main"].MergedUpstream, "the base branch itself is not redundant")
+		assert.Equal(t, [2]int{1, 3}, [2]int{members["feature"].Ahead, members["feature"].Behind})
+		assert.False(t, members["feature"].MergedUpstream)
+		assert.GreaterOrEqual(t, members["feature"].Age, 72*time.Hour)
+		assert.Equal(t, [2]int{0, 2}, [2]int{members["hotfix"].Ahead, members["hotfix"].Behind})
+		assert.True(t, members["hotfix"].MergedUpstream)
+
+		// a base branch missing on the remote leaves the distances unknown
+		operator.SetBaseBranch("develop")
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.Equal(t, "", helper.saved.BaseBranch)
+		assert.False(t, helper.saved.Members[2].MergedUpstream)
+	})
+
+	t.Run("member diff", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		feature := repo.branch("feature", base, "feature")
+		other := repo.branch("other", base, "other")
+		for _, ref := range []*models.GitRef{feature, other} {
+			_, fail := operator.AddAndPush(ctx, ref)
+			require.NoError(t, fail)
+		}
+		head := feature.Commit
+		for i := range diffMaxCommits + 2 {
+			head = repo.commit(fmt.Sprintf("update %d", i), head)
+		}
+		repo.remote["feature"] = head
+		files := make([]models.FileDiffStat, 0, diffMaxFiles+1)
+		for i := range cap(files) {
+			files = append(files, models.FileDiffStat{Path: fmt.Sprintf("file%d.txt", i), Added: 2, Deleted: 1})
+		}
+		repo.diffStats[head] = files
+
+		diff, err := operator.MemberDiff(ctx, &fakeViewHelper{}, "feature", head)
+		require.NoError(t, err)
+		assert.Equal(t, diffMaxCommits+2, diff.TotalCommits)
+		require.Len(t, diff.Commits, diffMaxCommits)
+		assert.Equal(t, head, diff.Commits[0].SHA, "latest first")
+		assert.Equal(t, fmt.Sprintf("update %d", diffMaxCommits+1), diff.Commits[0].Title)
+		assert.Equal(t, diffMaxFiles+1, diff.TotalFiles)
+		assert.Len(t, diff.Files, diffMaxFiles)
+		assert.Equal(t, [2]int{2 * (diffMaxFiles + 1), diffMaxFiles + 1}, [2]int{diff.Added, diff.Deleted})
+		assert.Equal(t, "https://gitlab.example.com/-/compare/"+feature.Commit+"..."+head, diff.CompareURL)
+
+		diff, err = operator.MemberDiff(ctx, &fakeViewHelper{}, "other", other.Commit)
+		require.NoError(t, err)
+		assert.True(t, diff.UpToDate())
+		_, err = operator.MemberDiff(ctx, &fakeViewHelper{}, "stranger", head)
+		assert.ErrorContains(t, err, "not a member")
+
+		// the view summarizes the updates of outdated members whose latest commit is there
+		helper := &fakeViewHelper{latestCommits: map[string]string{"feature": head, "other": other.Commit}}
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		require.NotNil(t, helper.saved.Members[0].Updates)
+		assert.Equal(t, diffMaxCommits+2, helper.saved.Members[0].Updates.TotalCommits)
+		assert.Nil(t, helper.saved.Members[1].Updates)
+		helper.latestCommits["feature"] = repo.commit("not fetched yet", head)
+		delete(repo.commits, helper.latestCommits["feature"])
+		require.NoError(t, operator.SyncMergeTrainView(ctx, helper))
+		assert.Nil(t, helper.saved.Members[0].Updates)
+		assert.Empty(t, helper.saved.Members[0].Warnings)
+	})
+
+	t.Run("load merge train", func(t *testing.T) {
+		repo := newFakeRepository()
+		base := repo.commit("initial commit")
+		operator := newOperator(repo)
+		for _, name := range []string{"feature1", "feature2"} {
+			_, fail := operator.AddAndPush(ctx, repo.branch(name, base, name))
+			require.NoError(t, fail)
+		}
+
+		loaded, err := LoadMergeTrainOperator(ctx, repo, "bb-branches/456", 123, 456)
+		require.NoError(t, err)
+		assert.Equal(t, operator.mergeTrain.Members, loaded.mergeTrain.Members)
+		require.NoError(t, loaded.Fetch(ctx))
+		assert.ElementsMatch(t, []string{"refs/heads/bb-branches/456", "refs/heads/feature1", "refs/heads/feature2"}, repo.fetched)
+		repo.fetchErr = errors.New("network is unreachable")
+		assert.ErrorIs(t, loaded.Fetch(ctx), repo.fetchErr)
+
+		// a fresh clone only has the remote bb branch
+		delete(repo.branches, "bb-branches/456")
+		loaded, err = LoadMergeTrainOperator(ctx, repo, "bb-branches/456", 123, 456)
+		require.NoError(t, err)
+		assert.Equal(t, operator.mergeTrain.Members, loaded.mergeTrain.Members)
+	})
+}
+
+// fakeViewHelper is a MergeTrainViewHelper keeping the saved view
+type fakeViewHelper struct {
+	pipelines map[string]*models.PipelineView
+	// latestCommits are the latest commits of branches, "latest-<branch>" if missing
+	latestCommits map[string]string
+	// lookupErrs fails the lookups of branches
+	lookupErrs map[string]error
+	saved      *models.MergeTrainView
+}
+
+func (h *fakeViewHelper) BranchURL(_ int, branchName string) string {
+	return "https://gitlab.example.com/-/tree/" + branchName
+}
+
+func (h *fakeViewHelper) CommitURL(_ int, commitSHA string) string {
+	return "https://gitlab.example.com/-/commit/" + commitSHA
+}
+
+func (h *fakeViewHelper) CompareURL(_ int, from, to string) string {
+	return "https://gitlab.example.com/-/compare/" + from + "..." + to
+}
+
+func (h *fakeViewHelper) GetBranchLatestCommit(_ int, branchName string) (*models.CommitView, error) {
+	if err := h.lookupErrs[branchName]; err != nil {
+		return nil, err
+	}
+	if commit, ok := h.latestCommits[branchName]; ok {
+		return &models.CommitView{SHA: commit}, nil
+	}
+	return &models.CommitView{SHA: "latest-" + branchName}, nil
+}
+
+func (h *fakeViewHelper) GetMergeRequestInfo(_ int, branchName string) (*models.MergeRequestView, error) {
+	if err := h.lookupErrs[branchName]; err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (h *fakeViewHelper) GetPipeline(_ int, commitSHA string) (*models.PipelineView, error) {
+	return h.pipelines[commitSHA], nil
+}
+
+func (h *fakeViewHelper) Save(view *models.MergeTrainView) error {
+	h.saved = view
+	return nil
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/jizhilong/branch-bot/git"
+	"github.com/jizhilong/branch-bot/models"
+)
+
+// Repository is the git repository a merge train is built in, implemented by *git.Repo
+type Repository interface {
+	// RevParse returns the commit hash for the given revision
+	RevParse(ctx context.Context, rev string) (string, error)
+	// GetCommitMessage returns the commit message for the given commit
+	GetCommitMessage(ctx context.Context, commit string) (string, error)
+	// Merge merges the commits into base with message, failing with *models.GitMergeFailResult on conflicts
+	Merge(ctx context.Context, message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitMergeResult, error)
+	// RewordCommit creates a copy of commit with a different message, returning the hash of the new commit
+	RewordCommit(ctx context.Context, commit, message string) (string, error)
+	// Validate runs validation commands against commit, returning the first failed validation
+	Validate(ctx context.Context, commit string, commands []string, timeout time.Duration) error
+	// IsAncestor reports whether ancestor is reachable from commit
+	IsAncestor(ctx context.Context, ancestor, commit string) (bool, error)
+	// AheadBehind counts the commits reachable from commit but not from base, and the other way around
+	AheadBehind(ctx context.Context, commit, base string) (ahead, behind int, err error)
+	// Log returns the commits reachable from to but not from from, latest first, at most limit of them
+	Log(ctx context.Context, from, to string, limit int) ([]models.CommitSummary, error)
+	// CommitTime returns the committer date of commit
+	CommitTime(ctx context.Context, commit string) (time.Time, error)
+	// MergeBase returns the best common ancestor of commit and a hypothetical merge of the others
+	MergeBase(ctx context.Context, commit string, others ...string) (string, error)
+	// DiffStat returns the lines changed per file between two commits
+	DiffStat(ctx context.Context, from, to string) ([]models.FileDiffStat, error)
+	// EnsureBranch points a local branch to commit, deleting it if commit is empty
+	EnsureBranch(ctx context.Context, name string, commit string) error
+	// Fetch fetches refs from origin, refs missing on the remote are skipped
+	Fetch(ctx context.Context, refs ...string) error
+	// PushRemote updates branch on remote to commit, deleting it if commit is empty
+	PushRemote(ctx context.Context, remote, branch, commit string) error
+}
+
+var _ Repository = (*git.Repo)(nil)
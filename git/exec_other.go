@@ -0,0 +1,9 @@
+//go:build !unix
+
+package git
+
+import "os/exec"
+
+// killProcessGroupOnCancel is a no-op on platforms without process groups,
+// only the command itself is killed when its context is done
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
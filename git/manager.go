@@ -0,0 +1,264 @@
+package git
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManagerOptions configures the lifecycle of clones kept by a Manager
+type ManagerOptions struct {
+	// DiskBudget limits the total size of clones in bytes, least recently used clones are evicted
+	// once it's exceeded. Zero means no limit.
+	DiskBudget int64
+	// IdleTimeout evicts clones not used for the duration, zero means clones are never evicted for being idle
+	IdleTimeout time.Duration
+	// MaintenanceInterval is the interval of checking, garbage collecting and evicting clones,
+	// zero disables maintenance
+	MaintenanceInterval time.Duration
+}
+
+// Manager keeps the clones of repositories under a directory. It serializes commands working on the
+// same clone, heals or re-clones clones left in a bad state, and evicts idle clones to stay within budget.
+type Manager struct {
+	dir  string
+	opts ManagerOptions
+
+	mu     sync.Mutex // guards clones and the timestamps of clones
+	clones map[string]*managedClone
+}
+
+// managedClone is a clone tracked by Manager
+type managedClone struct {
+	mu             sync.Mutex // held while the clone is in use or maintained
+	path           string
+	lastUsed       time.Time
+	lastMaintained time.Time
+}
+
+// NewManager creates a manager of the clones under dir
+func NewManager(dir string, opts ManagerOptions) *Manager {
+	return &Manager{dir: dir, opts: opts, clones: make(map[string]*managedClone)}
+}
+
+// Acquire returns the clone of remoteUrl at the relative path name, cloning it if it's missing or broken.
+// The clone is reserved for the caller until release is called.
+func (m *Manager) Acquire(ctx context.Context, name, remoteUrl string, opts Options) (*Repo, func(), error) {
+	c := m.clone(name, time.Now())
+	c.mu.Lock()
+	release := func() {
+		m.mu.Lock()
+		c.lastUsed = time.Now()
+		m.mu.Unlock()
+		c.mu.Unlock()
+	}
+	repo, err := m.open(ctx, c, remoteUrl, opts)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return repo, release, nil
+}
+
+// clone returns the tracked clone at the relative path name, tracking it with lastUsed if it's new
+func (m *Manager) clone(name string, lastUsed time.Time) *managedClone {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clones[name]
+	if !ok {
+		c = &managedClone{path: filepath.Join(m.dir, name), lastUsed: lastUsed}
+		m.clones[name] = c
+	}
+	return c
+}
+
+// open syncs the clone, throwing it away and cloning again if it can't be healed
+func (m *Manager) open(ctx context.Context, c *managedClone, remoteUrl string, opts Options) (*Repo, error) {
+	if _, err := os.Stat(filepath.Join(c.path, ".git")); err == nil {
+		repo, err := SyncRepo(ctx, c.path, remoteUrl, opts)
+		if err == nil {
+			err = repo.Heal(ctx)
+		}
+		if err == nil {
+			return repo, nil
+		}
+		if ctx.Err() != nil {
+			// a slow remote doesn't make the clone broken
+			return nil, err
+		}
+		slog.Warn("Cloning again broken repository", "path", c.path, "error", err)
+		if err := os.RemoveAll(c.path); err != nil {
+			return nil, fmt.Errorf("failed to remove broken clone: %w", err)
+		}
+	}
+	return SyncRepo(ctx, c.path, remoteUrl, opts)
+}
+
+// Run maintains the clones every maintenance interval until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	if m.opts.MaintenanceInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.opts.MaintenanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Maintain(ctx)
+		}
+	}
+}
+
+// Maintain checks and garbage collects idle clones, evicting broken clones, clones idle longer than the
+// idle timeout, and then the least recently used clones until all clones fit in the disk budget.
+// Clones in use are left alone.
+func (m *Manager) Maintain(ctx context.Context) {
+	if err := m.discover(); err != nil {
+		slog.Error("Failed to discover clones", "dir", m.dir, "error", err)
+	}
+	clones := m.leastRecentlyUsed()
+	now := time.Now()
+	for _, c := range clones {
+		if !c.mu.TryLock() {
+			continue
+		}
+		m.mu.Lock()
+		lastUsed, lastMaintained := c.lastUsed, c.lastMaintained
+		m.mu.Unlock()
+		switch {
+		case m.opts.IdleTimeout > 0 && now.Sub(lastUsed) > m.opts.IdleTimeout:
+			m.evict(c, "idle")
+		case now.Sub(lastMaintained) >= m.opts.MaintenanceInterval:
+			if err := m.maintain(ctx, c); err != nil && ctx.Err() == nil {
+				slog.Warn("Clone failed maintenance", "path", c.path, "error", err)
+				m.evict(c, "broken")
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	if m.opts.DiskBudget <= 0 {
+		return
+	}
+	sizes := make(map[*managedClone]int64, len(clones))
+	var total int64
+	for _, c := range clones {
+		sizes[c] = dirSize(c.path)
+		total += sizes[c]
+	}
+	for _, c := range clones {
+		if total <= m.opts.DiskBudget {
+			break
+		}
+		if sizes[c] == 0 || !c.mu.TryLock() {
+			continue
+		}
+		m.evict(c, "over disk budget")
+		total -= sizes[c]
+		c.mu.Unlock()
+	}
+}
+
+// maintain verifies the objects of a clone and garbage collects it
+func (m *Manager) maintain(ctx context.Context, c *managedClone) error {
+	if _, err := os.Stat(filepath.Join(c.path, ".git")); err != nil {
+		return nil
+	}
+	repo, err := New(c.path)
+	if err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"fsck", "--connectivity-only", "--no-dangling", "--no-progress"},
+		{"worktree", "prune"},
+		{"maintenance", "run", "--task=gc", "--quiet"},
+	} {
+		if err := repo.execCommandError(ctx, "git", args...); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	c.lastMaintained = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+// evict removes a clone from disk, it's cloned again on the next use. The clone stays tracked, so that
+// commands waiting for it keep serializing on the same lock.
+func (m *Manager) evict(c *managedClone, reason string) {
+	if _, err := os.Stat(c.path); err != nil {
+		return
+	}
+	slog.Info("Evicting clone", "path", c.path, "reason", reason)
+	if err := os.RemoveAll(c.path); err != nil {
+		slog.Error("Failed to evict clone", "path", c.path, "error", err)
+	}
+}
+
+// leastRecentlyUsed returns the tracked clones, least recently used first
+func (m *Manager) leastRecentlyUsed() []*managedClone {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clones := make([]*managedClone, 0, len(m.clones))
+	for _, c := range m.clones {
+		clones = append(clones, c)
+	}
+	slices.SortFunc(clones, func(a, b *managedClone) int {
+		return cmp.Or(a.lastUsed.Compare(b.lastUsed), strings.Compare(a.path, b.path))
+	})
+	return clones
+}
+
+// discover tracks clones found on disk, e.g. those cloned before a restart, using the modification
+// time of their index as the last use
+func (m *Manager) discover() error {
+	return filepath.WalkDir(m.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(path, ".git")); err != nil {
+			return nil
+		}
+		name, err := filepath.Rel(m.dir, path)
+		if err != nil {
+			return err
+		}
+		lastUsed := time.Now()
+		if info, err := os.Stat(filepath.Join(path, ".git", "index")); err == nil {
+			lastUsed = info.ModTime()
+		}
+		m.clone(name, lastUsed)
+		return filepath.SkipDir
+	})
+}
+
+// dirSize returns the total size of the files under dir
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,124 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeal(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTestRepo(t)
+	baseHash, err := repo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+	ref1 := repo.CreateBranch(base, "conflict1", "conflict.txt", "content from branch1")
+	repo.CreateBranch(base, "conflict2", "conflict.txt", "content from branch2")
+
+	// leave an unfinished merge and a lock file behind, as a killed git would
+	_, fail := repo.execCommand(ctx, "git", "merge", ref1.Commit)
+	require.NotNil(t, fail)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.Path(), "untracked.txt"), []byte("leftover"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.Path(), ".git", "index.lock"), nil, 0644))
+	_, err = repo.Merge(ctx, "Merge conflict1 into main", base, ref1)
+	require.Error(t, err)
+
+	require.NoError(t, repo.Heal(ctx))
+	assert.NoFileExists(t, filepath.Join(repo.Path(), ".git", "index.lock"))
+	assert.NoFileExists(t, filepath.Join(repo.Path(), ".git", "MERGE_HEAD"))
+	assert.NoFileExists(t, filepath.Join(repo.Path(), "untracked.txt"))
+	result, err := repo.Merge(ctx, "Merge conflict1 into main", base, ref1)
+	require.NoError(t, err)
+	assert.NotNil(t, result)
+}
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+	remote := NewTestRepo(t)
+	dir := t.TempDir()
+	manager := NewManager(dir, ManagerOptions{MaintenanceInterval: time.Hour})
+
+	acquire := func(name string) *Repo {
+		repo, release, err := manager.Acquire(ctx, name, remote.Path(), Options{})
+		require.NoError(t, err)
+		release()
+		return repo
+	}
+
+	t.Run("clone on first use", func(t *testing.T) {
+		repo := acquire("group/project")
+		assert.Equal(t, filepath.Join(dir, "group/project"), repo.Path())
+		_, err := repo.RevParse(ctx, "HEAD")
+		assert.NoError(t, err)
+	})
+
+	t.Run("serialize commands on a clone", func(t *testing.T) {
+		_, release, err := manager.Acquire(ctx, "group/project", remote.Path(), Options{})
+		require.NoError(t, err)
+		acquired := make(chan struct{})
+		go func() {
+			_, release, err := manager.Acquire(ctx, "group/project", remote.Path(), Options{})
+			if err == nil {
+				release()
+			}
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("clone acquired twice")
+		case <-time.After(100 * time.Millisecond):
+		}
+		release()
+		<-acquired
+	})
+
+	t.Run("clone broken clone again", func(t *testing.T) {
+		require.NoError(t, os.Remove(filepath.Join(dir, "group/project", ".git", "HEAD")))
+		repo := acquire("group/project")
+		_, err := repo.RevParse(ctx, "HEAD")
+		assert.NoError(t, err)
+	})
+
+	t.Run("maintain", func(t *testing.T) {
+		manager.Maintain(ctx)
+		assert.DirExists(t, filepath.Join(dir, "group/project"))
+		assert.False(t, manager.clones["group/project"].lastMaintained.IsZero())
+	})
+
+	t.Run("evict idle clones", func(t *testing.T) {
+		acquire("group/idle")
+		manager.opts.IdleTimeout = time.Hour
+		defer func() { manager.opts.IdleTimeout = 0 }()
+		manager.clones["group/idle"].lastUsed = time.Now().Add(-2 * time.Hour)
+		manager.Maintain(ctx)
+		assert.NoDirExists(t, filepath.Join(dir, "group/idle"))
+		assert.DirExists(t, filepath.Join(dir, "group/project"))
+	})
+
+	t.Run("evict least recently used clones over budget", func(t *testing.T) {
+		acquire("group/old")
+		acquire("group/new")
+		manager.clones["group/old"].lastUsed = time.Now().Add(-time.Minute)
+		// garbage collect the new clones first, so that their sizes stay put
+		manager.Maintain(ctx)
+		manager.opts.DiskBudget = dirSize(filepath.Join(dir, "group/new")) + dirSize(filepath.Join(dir, "group/project"))
+		defer func() { manager.opts.DiskBudget = 0 }()
+		manager.Maintain(ctx)
+		assert.NoDirExists(t, filepath.Join(dir, "group/old"))
+		assert.DirExists(t, filepath.Join(dir, "group/new"))
+		assert.DirExists(t, filepath.Join(dir, "group/project"))
+	})
+
+	t.Run("discover clones after restart", func(t *testing.T) {
+		restarted := NewManager(dir, ManagerOptions{})
+		require.NoError(t, restarted.discover())
+		assert.Contains(t, restarted.clones, "group/project")
+		assert.Contains(t, restarted.clones, "group/new")
+	})
+}
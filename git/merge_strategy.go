@@ -0,0 +1,178 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jizhilong/branch-bot/models"
+)
+
+// matchPath reports whether the file path matches the pattern of a merge strategy.
+//
+// Patterns without a slash match file names in any directory, a trailing "/**" matches
+// everything under a directory, other patterns match the full path.
+func matchPath(pattern, filePath string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return strings.HasPrefix(filePath, dir+"/")
+	}
+	if !strings.Contains(pattern, "/") {
+		filePath = path.Base(filePath)
+	}
+	matched, err := path.Match(pattern, filePath)
+	return err == nil && matched
+}
+
+// findMergeStrategy returns the first configured merge strategy matching the file path
+func (r *Repo) findMergeStrategy(filePath string) *models.PathMergeStrategy {
+	for i, s := range r.opts.MergeStrategies {
+		if matchPath(s.Pattern, filePath) {
+			return &r.opts.MergeStrategies[i]
+		}
+	}
+	return nil
+}
+
+// resolveConflicts applies the configured merge strategies to the unmerged paths of an ongoing merge,
+// returning the resolved files and whether all conflicts are resolved.
+func (r *Repo) resolveConflicts(ctx context.Context) ([]models.FileAutoResolution, bool) {
+	if len(r.opts.MergeStrategies) == 0 {
+		return nil, false
+	}
+	res, err := r.execCommand(ctx, "git", "diff", "--name-only", "--diff-filter=U", "-z")
+	if err != nil {
+		return nil, false
+	}
+	paths := strings.FieldsFunc(res.Stdout, func(c rune) bool { return c == 0 })
+	if len(paths) == 0 {
+		// e.g. octopus merge gave up without leaving conflicts in the working tree
+		return nil, false
+	}
+
+	var resolved []models.FileAutoResolution
+	allResolved := true
+	for _, p := range paths {
+		strategy := r.findMergeStrategy(p)
+		if strategy == nil {
+			allResolved = false
+			continue
+		}
+		if err := r.applyMergeStrategy(ctx, strategy, p); err != nil {
+			allResolved = false
+			continue
+		}
+		resolved = append(resolved, models.FileAutoResolution{Path: p, Strategy: strategy.Strategy})
+	}
+	return resolved, allResolved
+}
+
+// applyMergeStrategy resolves the conflict of a single unmerged path and stages the result
+func (r *Repo) applyMergeStrategy(ctx context.Context, strategy *models.PathMergeStrategy, filePath string) error {
+	switch strategy.Strategy {
+	case models.MergeStrategyOurs:
+		if err := r.execCommandError(ctx, "git", "checkout", "--ours", "--", filePath); err != nil {
+			return err
+		}
+	case models.MergeStrategyTheirs:
+		if err := r.execCommandError(ctx, "git", "checkout", "--theirs", "--", filePath); err != nil {
+			return err
+		}
+	case models.MergeStrategyUnion, models.MergeStrategyCommand:
+		if err := r.mergeFileStages(ctx, strategy, filePath); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown merge strategy %s", strategy.Strategy)
+	}
+	return r.execCommandError(ctx, "git", "add", "--", filePath)
+}
+
+// mergeFileStages merges the base, ours and theirs stages of an unmerged path with
+// git merge-file --union or the resolver command, writing the result to the working tree.
+func (r *Repo) mergeFileStages(ctx context.Context, strategy *models.PathMergeStrategy, filePath string) error {
+	tmpDir, err := os.MkdirTemp("", "bb-merge-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Extract stages into temporary files, a missing base stage means both sides added the file
+	stageFiles := make([]string, 3)
+	for i := range stageFiles {
+		stageFiles[i] = filepath.Join(tmpDir, fmt.Sprintf("stage%d", i+1))
+		content := ""
+		if res, err := r.execCommand(ctx, "git", "show", fmt.Sprintf(":%d:%s", i+1, filePath)); err == nil {
+			content = res.Stdout
+		} else if i > 0 {
+			return err
+		}
+		if err := os.WriteFile(stageFiles[i], []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	base, ours, theirs := stageFiles[0], stageFiles[1], stageFiles[2]
+
+	if strategy.Strategy == models.MergeStrategyUnion {
+		if err := r.execCommandError(ctx, "git", "merge-file", "--union", ours, base, theirs); err != nil {
+			return err
+		}
+	} else {
+		command := strings.NewReplacer(
+			"%O", shellQuote(base),
+			"%A", shellQuote(ours),
+			"%B", shellQuote(theirs),
+			"%P", shellQuote(filePath),
+		).Replace(strategy.Command)
+		if err := r.execCommandError(ctx, "sh", "-c", command); err != nil {
+			return err
+		}
+	}
+
+	merged, err := os.ReadFile(ours)
+	if err != nil {
+		return err
+	}
+	mode, err := r.unmergedMode(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	worktreeFile := filepath.Join(r.path, filePath)
+	if err := os.WriteFile(worktreeFile, merged, mode); err != nil {
+		return err
+	}
+	// WriteFile only applies the mode to new files
+	return os.Chmod(worktreeFile, mode)
+}
+
+// unmergedMode returns the file mode of an unmerged path merged from its stages, a side changing the mode
+// of the base wins, so that resolving conflicts keeps executable files executable
+func (r *Repo) unmergedMode(ctx context.Context, filePath string) (os.FileMode, error) {
+	res, err := r.execCommand(ctx, "git", "ls-files", "--stage", "-z", "--", filePath)
+	if err != nil {
+		return 0, err
+	}
+	modes := make(map[string]string)
+	for _, entry := range strings.Split(res.Stdout, "\x00") {
+		// each entry looks like "<mode> <object> <stage>\t<path>"
+		fields := strings.Fields(strings.SplitN(entry, "\t", 2)[0])
+		if len(fields) == 3 {
+			modes[fields[2]] = fields[0]
+		}
+	}
+	mode := modes["2"]
+	if theirs, ok := modes["3"]; ok && (mode == "" || mode == modes["1"]) {
+		mode = theirs
+	}
+	if mode == "100755" {
+		return 0755, nil
+	}
+	return 0644, nil
+}
+
+// shellQuote quotes s as a single argument for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,119 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"CHANGELOG.md", "CHANGELOG.md", true},
+		{"CHANGELOG.md", "docs/CHANGELOG.md", true},
+		{"*.lock", "web/yarn.lock", true},
+		{"*.lock", "web/yarn.lock.bak", false},
+		{"web/*.lock", "web/yarn.lock", true},
+		{"web/*.lock", "api/yarn.lock", false},
+		{"gen/**", "gen/api/client.go", true},
+		{"gen/**", "pkg/gen/client.go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchPath(tt.pattern, tt.path))
+		})
+	}
+}
+
+func TestMergeWithStrategies(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTestRepo(t)
+	repo.opts.MergeStrategies = []models.PathMergeStrategy{
+		{Pattern: "CHANGELOG.md", Strategy: models.MergeStrategyUnion},
+		{Pattern: "*.lock", Strategy: models.MergeStrategyTheirs},
+		{Pattern: "gen/**", Strategy: models.MergeStrategyCommand, Command: "echo regenerated > %A"},
+		{Pattern: "*.sh", Strategy: models.MergeStrategyUnion},
+		{Pattern: "env/**", Strategy: models.MergeStrategyCommand, Command: "env > %A"},
+	}
+	// Get base commit
+	baseHash, err := repo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	base := &models.GitRef{Name: "main", Commit: baseHash}
+
+	readFile := func(commit, file string) string {
+		res, err := repo.execCommand(ctx, "git", "show", commit+":"+file)
+		require.Nil(t, err)
+		return res.Stdout
+	}
+
+	t.Run("union", func(t *testing.T) {
+		ref1 := repo.CreateBranch(base, "changelog1", "CHANGELOG.md", "feature 1\n")
+		ref2 := repo.CreateBranch(base, "changelog2", "CHANGELOG.md", "feature 2\n")
+		result, fail := repo.Merge(ctx, "Merge changelog1, changelog2", ref1, ref2)
+		require.Nil(t, fail)
+		assert.Equal(t, []models.FileAutoResolution{{Path: "CHANGELOG.md", Strategy: "union"}}, result.AutoResolved)
+		assert.Equal(t, "feature 1\nfeature 2\n", readFile(result.Commit, "CHANGELOG.md"))
+	})
+
+	t.Run("theirs", func(t *testing.T) {
+		ref1 := repo.CreateBranch(base, "lock1", "yarn.lock", "lock 1\n")
+		ref2 := repo.CreateBranch(base, "lock2", "yarn.lock", "lock 2\n")
+		result, fail := repo.Merge(ctx, "Merge lock1, lock2", ref1, ref2)
+		require.Nil(t, fail)
+		assert.Equal(t, "lock 2\n", readFile(result.Commit, "yarn.lock"))
+	})
+
+	t.Run("command", func(t *testing.T) {
+		ref1 := repo.CreateBranch(base, "gen1", "gen/client.go", "client 1\n")
+		ref2 := repo.CreateBranch(base, "gen2", "gen/client.go", "client 2\n")
+		result, fail := repo.Merge(ctx, "Merge gen1, gen2", ref1, ref2)
+		require.Nil(t, fail)
+		assert.Equal(t, "regenerated\n", readFile(result.Commit, "gen/client.go"))
+	})
+
+	t.Run("file modes are kept", func(t *testing.T) {
+		script := repo.CreateBranch(base, "script", "run.sh", "echo base\n")
+		ref1 := repo.CreateBranch(script, "script1", "run.sh", "echo 1\n")
+		ref2 := repo.CreateBranch(script, "script2", "run.sh", "echo 2\n")
+		require.NoError(t, os.Chmod(filepath.Join(repo.Path(), "run.sh"), 0755))
+		ref2 = repo.UpdateBranch("script2", "run.sh", "echo 2\n")
+		result, fail := repo.Merge(ctx, "Merge script1, script2", ref1, ref2)
+		require.Nil(t, fail)
+		res, err := repo.execCommand(ctx, "git", "ls-tree", result.Commit, "run.sh")
+		require.Nil(t, err)
+		assert.True(t, strings.HasPrefix(res.Stdout, "100755 "), "script2 made run.sh executable: %s", res.Stdout)
+	})
+
+	t.Run("resolvers don't see credentials", func(t *testing.T) {
+		t.Setenv("BB_GITLAB_TOKEN", "glpat-secret")
+		ref1 := repo.CreateBranch(base, "env1", "env/vars", "env 1\n")
+		ref2 := repo.CreateBranch(base, "env2", "env/vars", "env 2\n")
+		result, fail := repo.Merge(ctx, "Merge env1, env2", ref1, ref2)
+		require.Nil(t, fail)
+		vars := readFile(result.Commit, "env/vars")
+		assert.Contains(t, vars, "PATH=")
+		assert.NotContains(t, vars, "glpat-secret")
+	})
+
+	t.Run("unresolved conflicts are reported", func(t *testing.T) {
+		ref1 := repo.CreateBranch(base, "mixed1", "CHANGELOG.md", "mixed 1\n")
+		ref1 = repo.UpdateBranch("mixed1", "main.go", "package main // 1\n")
+		ref2 := repo.CreateBranch(base, "mixed2", "CHANGELOG.md", "mixed 2\n")
+		ref2 = repo.UpdateBranch("mixed2", "main.go", "package main // 2\n")
+		result, fail := repo.Merge(ctx, "Merge mixed1, mixed2", ref1, ref2)
+		assert.Nil(t, result)
+		if mergeFail, ok := fail.(*models.GitMergeFailResult); assert.True(t, ok) {
+			require.Len(t, mergeFail.FailedFiles, 1)
+			assert.Equal(t, "main.go", mergeFail.FailedFiles[0].Path)
+		}
+	})
+}
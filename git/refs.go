@@ -0,0 +1,75 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// InvalidRefError is returned when a branch name or revision can't be passed to git safely
+type InvalidRefError struct {
+	Ref    string
+	Reason string
+}
+
+func (e *InvalidRefError) Error() string {
+	return fmt.Sprintf("invalid ref %q: %s", e.Ref, e.Reason)
+}
+
+// CheckBranchName validates a branch name with the rules of git check-ref-format --branch,
+// additionally refusing names that could be mistaken for command line options.
+func CheckBranchName(name string) error {
+	invalid := func(reason string) error {
+		return &InvalidRefError{Ref: name, Reason: reason}
+	}
+	if err := CheckRevision(name); err != nil {
+		return err
+	}
+	if name == "HEAD" || name == "@" {
+		return invalid("reserved name")
+	}
+	if i := strings.IndexAny(name, "~^:?*[\\"); i >= 0 {
+		return invalid(fmt.Sprintf("contains %q", name[i]))
+	}
+	if strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return invalid("contains \"..\" or \"@{\"")
+	}
+	if strings.HasSuffix(name, ".") {
+		return invalid("ends with \".\"")
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" {
+			return invalid("contains an empty path component")
+		}
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return invalid("path component starts with \".\" or ends with \".lock\"")
+		}
+	}
+	return nil
+}
+
+// CheckRevision validates a revision such as a commit hash or "<branch>^{commit}" passed to git,
+// refusing empty revisions, whitespace, control characters and anything looking like an option.
+func CheckRevision(rev string) error {
+	if rev == "" {
+		return &InvalidRefError{Ref: rev, Reason: "empty"}
+	}
+	if strings.HasPrefix(rev, "-") {
+		return &InvalidRefError{Ref: rev, Reason: "starts with \"-\""}
+	}
+	for _, c := range rev {
+		if c <= ' ' || c == 0x7f {
+			return &InvalidRefError{Ref: rev, Reason: "contains whitespace or control characters"}
+		}
+	}
+	return nil
+}
+
+// checkRevisions validates all revisions with CheckRevision
+func checkRevisions(revs ...string) error {
+	for _, rev := range revs {
+		if err := CheckRevision(rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckBranchName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"feature", true},
+		{"feature/login-page", true},
+		{"release-1.2", true},
+		{"", false},
+		{"--upload-pack=touch /tmp/pwned", false},
+		{"-f", false},
+		{"feature branch", false},
+		{"feature\nbranch", false},
+		{"feature..main", false},
+		{"feature@{1}", false},
+		{"@", false},
+		{"HEAD", false},
+		{"feature~1", false},
+		{"feature^", false},
+		{"origin:main", false},
+		{"feature*", false},
+		{"feature\\main", false},
+		{"/feature", false},
+		{"feature/", false},
+		{"feature//main", false},
+		{"feature.", false},
+		{".hidden/feature", false},
+		{"feature/.hidden", false},
+		{"feature.lock", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckBranchName(tt.name)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				var invalidRef *InvalidRefError
+				assert.ErrorAs(t, err, &invalidRef)
+			}
+		})
+	}
+}
+
+func TestCheckRevision(t *testing.T) {
+	assert.NoError(t, CheckRevision("HEAD"))
+	assert.NoError(t, CheckRevision("0123456789abcdef0123456789abcdef01234567"))
+	assert.NoError(t, CheckRevision("feature^{commit}"))
+	assert.Error(t, CheckRevision(""))
+	assert.Error(t, CheckRevision("--output=/tmp/pwned"))
+	assert.Error(t, CheckRevision("HEAD --all"))
+}
+
+func TestRepoRefusesMaliciousInput(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTestRepo(t)
+	pwned := filepath.Join(t.TempDir(), "pwned")
+
+	_, err := repo.RevParse(ctx, "--output="+pwned)
+	assert.Error(t, err)
+	assert.Error(t, repo.EnsureBranch(ctx, "--upload-pack=touch "+pwned, "HEAD"))
+	assert.Error(t, repo.PushRemote(ctx, "origin", "feature", "--upload-pack=touch "+pwned))
+	_, err = repo.Merge(ctx, "message", &models.GitRef{Name: "main", Commit: "HEAD"},
+		&models.GitRef{Name: "evil", Commit: "--strategy-option=theirs"})
+	assert.Error(t, err)
+
+	// transports able to run commands are not allowed, even if configured as remote
+	require.NoError(t, repo.EnsureRemote(ctx, "evil", "ext::sh -c touch% "+pwned))
+	assert.Error(t, repo.execCommandError(ctx, "git", "fetch", "evil"))
+	assert.NoFileExists(t, pwned)
+
+	// local paths are only allowed when given directly, not e.g. by submodules
+	other := NewTestRepo(t)
+	_, fail := repo.execCommand(ctx, "git", "fetch", other.Path())
+	assert.Nil(t, fail)
+	_, fail = repo.execCommandEnv(ctx, []string{"GIT_PROTOCOL_FROM_USER=0"}, "git", "fetch", other.Path())
+	assert.NotNil(t, fail)
+}
+
+func TestRepoDisablesHooks(t *testing.T) {
+	ctx := context.Background()
+	repo := NewTestRepo(t)
+	pwned := filepath.Join(t.TempDir(), "pwned")
+	hook := filepath.Join(repo.Path(), ".git", "hooks", "post-checkout")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\ntouch "+pwned+"\n"), 0755))
+
+	base, err := repo.RevParse(ctx, "HEAD")
+	require.NoError(t, err)
+	feature := repo.CreateBranch(&models.GitRef{Name: "main", Commit: base}, "feature", "file.txt", "content")
+	_, err = repo.Merge(ctx, "message", &models.GitRef{Name: "main", Commit: base}, feature)
+	require.NoError(t, err)
+	assert.NoFileExists(t, pwned)
+}
@@ -1,23 +1,109 @@
 package git
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jizhilong/branch-bot/models"
 )
 
 // Repo represents a Git repository
 type Repo struct {
-	path string // absolute path to the repository
+	path string  // absolute path to the repository
+	opts Options // options of operations on the repository
 }
 
-// SyncRepo ensures the repository exists and is up-to-date with the remote
-func SyncRepo(repoPath, remoteUrl string) (*Repo, error) {
+// Options customizes operations on a repository
+type Options struct {
+	// MergeStrategies resolve merge conflicts of matching paths automatically, the first match wins
+	MergeStrategies []models.PathMergeStrategy
+	// Credentials authenticate with the remote over http(s). They are handed to git by a credential helper
+	// reading the environment, so they never end up in remote urls or .git/config.
+	Credentials *Credentials
+	// SSH authenticates with the remote over ssh
+	SSH *SSHTransport
+	// Timeouts limit the time of cloning, fetching, merging and pushing
+	Timeouts Timeouts
+	// CloneFilter makes a partial clone with git clone --filter, like blob:none for a blobless clone
+	// or tree:0 for a treeless clone. Missing objects are fetched on demand.
+	CloneFilter string
+	// Reference is a local repository lending its objects to new clones through alternates, if it exists
+	Reference string
+	// Identity overrides the author and committer of new commits, which default to branch-bot
+	Identity *Identity
+	// Signer signs new commits, including merge commits and reworded commits
+	Signer *Signer
+}
+
+// Timeouts limit the time of git operations, zero means no limit
+type Timeouts struct {
+	Clone time.Duration
+	Fetch time.Duration
+	Merge time.Duration
+	Push  time.Duration
+}
+
+// Credentials are the username and password of a remote
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// credentialHelper answers git credential requests with the credentials in the environment
+const credentialHelper = `!f() { test "$1" = get && echo "username=$BB_GIT_USERNAME" && echo "password=$BB_GIT_PASSWORD"; }; f`
+
+// gitArgs returns the config options passing the credentials, identity and signing key to git
+func (o Options) gitArgs() []string {
+	var args []string
+	if o.Credentials != nil {
+		args = append(args, "-c", "credential.helper=", "-c", "credential.helper="+credentialHelper)
+	}
+	if o.Identity != nil {
+		args = append(args, "-c", "user.name="+o.Identity.Name, "-c", "user.email="+o.Identity.Email)
+	}
+	if o.Signer != nil {
+		args = append(args, o.Signer.gitArgs()...)
+	}
+	return args
+}
+
+// gitEnv returns the environment variables read by the credential helper, ssh and the signing program
+func (o Options) gitEnv() []string {
+	var env []string
+	if o.Credentials != nil {
+		env = append(env, "BB_GIT_USERNAME="+o.Credentials.Username, "BB_GIT_PASSWORD="+o.Credentials.Password)
+	}
+	if o.SSH != nil {
+		env = append(env, o.SSH.gitEnv()...)
+	}
+	if o.Signer != nil {
+		env = append(env, o.Signer.gitEnv()...)
+	}
+	return env
+}
+
+// secrets returns the values to redact from command results
+func (o Options) secrets() []string {
+	if o.Credentials == nil {
+		return nil
+	}
+	return []string{o.Credentials.Password}
+}
+
+// SyncRepo ensures the repository exists and its origin points to the remote, which is rewritten when
+// the remote url changes, e.g. when switching between https and ssh.
+// Existing clones are not refreshed, commands fetch the refs they need with Fetch.
+func SyncRepo(ctx context.Context, repoPath, remoteUrl string, opts Options) (*Repo, error) {
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
 		if err := os.MkdirAll(repoPath, 0755); err != nil {
 			return nil, fmt.Errorf("failed to create repo directory: %w", err)
@@ -27,14 +113,14 @@ func SyncRepo(repoPath, remoteUrl string) (*Repo, error) {
 	var repo *Repo
 	if _, err := os.Stat(gitDirPath); os.IsNotExist(err) {
 		// clone from remote
-		repo, err = Clone(remoteUrl, repoPath)
+		repo, err = Clone(ctx, remoteUrl, repoPath, opts)
 		if err != nil {
 			return nil, fmt.Errorf("failed to clone repository: %w", err)
 		}
-		if err = repo.Config("user.name", "branch-bot"); err != nil {
+		if err = repo.Config(ctx, "user.name", "branch-bot"); err != nil {
 			return nil, fmt.Errorf("failed to set user name: %w", err)
 		}
-		if err = repo.Config("user.email", "operator@branch-bot.localhost"); err != nil {
+		if err = repo.Config(ctx, "user.email", "operator@branch-bot.localhost"); err != nil {
 			return nil, fmt.Errorf("failed to set user email: %w", err)
 		}
 	} else {
@@ -42,24 +128,41 @@ func SyncRepo(repoPath, remoteUrl string) (*Repo, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to open repository: %w", err)
 		}
-		err = repo.EnsureRemote("origin", remoteUrl)
+		repo.opts = opts
+		// remote urls with credentials written by earlier versions are replaced here
+		err = repo.EnsureRemote(ctx, "origin", remoteUrl)
 		if err != nil {
 			return nil, fmt.Errorf("failed to ensure remote: %w", err)
 		}
-		err = repo.RefreshRemote()
-		if err != nil {
-			return nil, fmt.Errorf("failed to refresh remote: %w", err)
-		}
 	}
 	return repo, nil
 }
 
-func Clone(url, path string) (*Repo, error) {
-	cmd := exec.Command("git", "clone", url, path)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("failed to clone repository: %s: %w", output, err)
+// Clone clones url into path, returning the repository operated with opts
+func Clone(ctx context.Context, url, path string, opts Options) (*Repo, error) {
+	_, err := withTimeout(ctx, "clone", opts.Timeouts.Clone, func(ctx context.Context) (struct{}, error) {
+		args := []string{"clone"}
+		if opts.CloneFilter != "" {
+			args = append(args, "--filter="+opts.CloneFilter)
+		}
+		if opts.Reference != "" {
+			args = append(args, "--reference-if-able", opts.Reference)
+		}
+		cmd := gitCommand(ctx, opts, append(args, "--end-of-options", url, path)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return struct{}{}, fmt.Errorf("failed to clone repository: %s: %w", models.RedactSecrets(string(output), opts.secrets()...), err)
+		}
+		return struct{}{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	repo, err := New(path)
+	if err != nil {
+		return nil, err
 	}
-	return New(path)
+	repo.opts = opts
+	return repo, nil
 }
 
 // New creates a new Repo instance
@@ -79,10 +182,71 @@ func New(path string) (*Repo, error) {
 	return &Repo{path: absPath}, nil
 }
 
+// hardenedConfig keeps git commands from running code provided by a repository or a crafted remote url:
+// hooks and fsmonitor are disabled, and only the transports needed to talk to GitLab are allowed.
+var hardenedConfig = []string{
+	"-c", "core.hooksPath=/dev/null",
+	"-c", "core.fsmonitor=false",
+	"-c", "protocol.allow=never",
+	"-c", "protocol.https.allow=always",
+	"-c", "protocol.http.allow=always",
+	"-c", "protocol.ssh.allow=always",
+	// git's default for local paths, they are only allowed when given directly rather than e.g. by submodules
+	"-c", "protocol.file.allow=user",
+}
+
+// hardenedEnv ignores the system wide git config and never prompts for credentials
+var hardenedEnv = []string{"GIT_CONFIG_NOSYSTEM=1", "GIT_TERMINAL_PROMPT=0"}
+
+// gitCommand returns a git command running with hardenedConfig, hardenedEnv and the credentials of opts,
+// the command is killed together with its children once ctx is done
+func gitCommand(ctx context.Context, opts Options, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", slices.Concat(hardenedConfig, opts.gitArgs(), args)...)
+	cmd.Env = slices.Concat(os.Environ(), hardenedEnv, opts.gitEnv())
+	killProcessGroupOnCancel(cmd)
+	return cmd
+}
+
+// withTimeout runs an operation with ctx limited to timeout, zero means no limit. If the operation fails
+// because ctx is done, the failure is reported as *models.GitTimeoutError.
+func withTimeout[T any](ctx context.Context, operation string, timeout time.Duration, fn func(context.Context) (T, error)) (T, error) {
+	opCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	result, err := fn(opCtx)
+	if err != nil && opCtx.Err() != nil {
+		timeoutErr := &models.GitTimeoutError{Operation: operation, Cause: opCtx.Err(), Err: err}
+		// the timeout of the operation is only to blame if ctx itself wasn't done first
+		if ctx.Err() == nil {
+			timeoutErr.Timeout = timeout
+		}
+		return result, timeoutErr
+	}
+	return result, err
+}
+
 // execCommand executes a git command in the repository, returning the detail of result or error
-func (r *Repo) execCommand(name string, args ...string) (*models.CommandExecResult, *models.CommandExecFail) {
-	cmd := exec.Command(name, args...)
+func (r *Repo) execCommand(ctx context.Context, name string, args ...string) (*models.CommandExecResult, *models.CommandExecFail) {
+	return r.execCommandEnv(ctx, nil, name, args...)
+}
+
+// execCommandEnv executes a git command in the repository with extra environment variables
+func (r *Repo) execCommandEnv(ctx context.Context, env []string, name string, args ...string) (*models.CommandExecResult, *models.CommandExecFail) {
+	var cmd *exec.Cmd
+	if name == "git" {
+		cmd = gitCommand(ctx, r.opts, args...)
+	} else {
+		// other commands are configured by users, like resolvers of merge strategies, keep credentials from them
+		cmd = exec.CommandContext(ctx, name, args...)
+		cmd.Env = validationEnv()
+		killProcessGroupOnCancel(cmd)
+	}
 	cmd.Dir = r.path
+	cmd.Env = append(cmd.Env, env...)
+	// Cmdline leaves out the hardening options, which are the same for all commands
 	res := &models.CommandExecResult{
 		Cmdline: fmt.Sprintf("%s %s", name, strings.Join(args, " ")),
 	}
@@ -94,21 +258,28 @@ func (r *Repo) execCommand(name string, args ...string) (*models.CommandExecResu
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		res.Stderr = string(exitErr.Stderr)
 		res.Status = exitErr.Error()
+	} else {
+		res.Status = err.Error()
 	}
+	// failures end up in logs and issues, keep credentials out of them
+	res.Redact(r.opts.secrets()...)
 	return nil, (*models.CommandExecFail)(res)
 }
 
 // execCommandError executes a git command in the repository, returning error if failed
-func (r *Repo) execCommandError(name string, args ...string) error {
-	if _, err := r.execCommand(name, args...); err != nil {
+func (r *Repo) execCommandError(ctx context.Context, name string, args ...string) error {
+	if _, err := r.execCommand(ctx, name, args...); err != nil {
 		return err
 	}
 	return nil
 }
 
 // RevParse returns the commit hash for the given revision
-func (r *Repo) RevParse(rev string) (string, error) {
-	res, err := r.execCommand("git", "rev-parse", rev)
+func (r *Repo) RevParse(ctx context.Context, rev string) (string, error) {
+	if err := CheckRevision(rev); err != nil {
+		return "", err
+	}
+	res, err := r.execCommand(ctx, "git", "rev-parse", "--verify", "--end-of-options", rev)
 	if err != nil {
 		return "", err
 	}
@@ -123,186 +294,621 @@ func (r *Repo) RevParse(rev string) (string, error) {
 // 2. If direct merge fails with multiple commits, tries two-phase merge:
 //   - First merges all commits except the last one
 //   - Then tries to merge the last commit
-//   - If second phase fails, checks which branches conflict with the last one
-func (r *Repo) Merge(message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitRef, error) {
+//   - If it still fails, bisects the refs down to a minimal set of branches that conflict
+//
+// All the steps together are limited by the merge timeout.
+func (r *Repo) Merge(ctx context.Context, message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitMergeResult, error) {
+	if err := CheckRevision(base.Commit); err != nil {
+		return nil, err
+	}
+	for _, c := range commits {
+		if err := CheckRevision(c.Commit); err != nil {
+			return nil, err
+		}
+	}
+	return withTimeout(ctx, "merge", r.opts.Timeouts.Merge, func(ctx context.Context) (*models.GitMergeResult, error) {
+		return r.merge(ctx, message, base, commits...)
+	})
+}
+
+// merge implements Merge
+func (r *Repo) merge(ctx context.Context, message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitMergeResult, error) {
 	// Try direct merge first
-	if ref, fail := r.doMerge(message, base, commits...); ref != nil {
+	ref, fail := r.doMerge(ctx, message, base, commits...)
+	if ref != nil {
 		return ref, nil
-	} else if len(commits) <= 1 {
+	}
+	if ctx.Err() != nil {
 		return nil, fail
 	}
+	refs := append([]*models.GitRef{base}, commits...)
+	if len(commits) > 1 {
+		// Try two-phase merge for multiple commits
+		ref, fail = r.mergeInTwoPhases(ctx, message, refs)
+		if ref != nil {
+			return ref, nil
+		}
+	}
+
+	var mergeFail *models.GitMergeFailResult
+	if errors.As(fail, &mergeFail) {
+		return nil, r.diagnoseConflict(ctx, refs, mergeFail)
+	}
+	return nil, fail
+}
 
-	// Try two-phase merge for multiple commits
-	previousRef, previousFail := r.doMerge("partial", base, commits[:len(commits)-1]...)
+// mergeInTwoPhases merges all refs except the last one onto the first ref, then merges the last one,
+// so that a conflict is reported by a plain two-head merge instead of an octopus merge.
+func (r *Repo) mergeInTwoPhases(ctx context.Context, message string, refs []*models.GitRef) (*models.GitMergeResult, error) {
+	if len(refs) <= 2 {
+		return r.doMerge(ctx, message, refs[0], refs[1:]...)
+	}
+	previousRef, previousFail := r.doMerge(ctx, "partial", refs[0], refs[1:len(refs)-1]...)
 	if previousRef == nil {
 		return nil, previousFail
 	}
+	finalRef, finalFail := r.doMerge(ctx, message, &previousRef.GitRef, refs[len(refs)-1])
+	if finalRef == nil {
+		return nil, finalFail
+	}
+	finalRef.AutoResolved = append(previousRef.AutoResolved, finalRef.AutoResolved...)
+	return finalRef, nil
+}
 
-	// Try merging the last commit
-	finalRef, finalFail := r.doMerge(message, previousRef, commits[len(commits)-1])
-	if finalRef != nil {
-		return finalRef, nil
+// diagnoseConflict narrows refs, which are known to fail merging with fail, down to a minimal
+// subset of refs that still can't be merged together, and reports that subset as the conflict branches.
+//
+// Culprits are collected from the back: each round bisects for the shortest prefix of the remaining
+// candidates that still conflicts together with the culprits found so far. The last ref of that prefix
+// is a culprit, and only the refs before it remain candidates for the next round.
+func (r *Repo) diagnoseConflict(ctx context.Context, refs []*models.GitRef, fail *models.GitMergeFailResult) *models.GitMergeFailResult {
+	// subset returns the refs at the given indexes plus the first n candidates, in their original order
+	subset := func(indexes []int, n int) []*models.GitRef {
+		result := make([]*models.GitRef, 0, len(indexes)+n)
+		for i, ref := range refs {
+			if i < n || slices.Contains(indexes, i) {
+				result = append(result, ref)
+			}
+		}
+		return result
 	}
 
-	var mergeFail *models.GitMergeFailResult
-	if errors.As(finalFail, &mergeFail) {
-		// Check which branches conflict with the last one
-		lastCommit := commits[len(commits)-1]
-		for _, commit := range commits[:len(commits)-1] {
-			if r.checkConflict(lastCommit, commit) {
-				mergeFail.ConflictBranches = append(mergeFail.ConflictBranches, commit.Name)
+	var culprits []int
+	candidates := len(refs)
+	for {
+		if len(culprits) >= 2 {
+			culpritFail, err := r.mergeConflict(ctx, subset(culprits, 0))
+			if err != nil {
+				return fail
+			}
+			if culpritFail != nil {
+				slices.Sort(culprits)
+				for _, i := range culprits {
+					culpritFail.ConflictBranches = append(culpritFail.ConflictBranches, refs[i].Name)
+				}
+				culpritFail.ConflictMinimal = true
+				return culpritFail
 			}
 		}
-		mergeFail.ConflictBranches = append(mergeFail.ConflictBranches, lastCommit.Name)
-		return nil, mergeFail
+		if candidates == 0 {
+			// merge conflicts are not always monotonic, keep the original failure if bisecting went astray
+			return fail
+		}
+		lo, hi := 0, candidates-1
+		for lo < hi {
+			mid := (lo + hi) / 2
+			midFail, err := r.mergeConflict(ctx, subset(culprits, mid+1))
+			if err != nil {
+				return fail
+			}
+			if midFail != nil {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		culprits = append(culprits, lo)
+		candidates = lo
+	}
+}
+
+// mergeConflict tries to merge refs, returning the merge failure if they conflict.
+// Failures other than merge conflicts are returned as error.
+func (r *Repo) mergeConflict(ctx context.Context, refs []*models.GitRef) (*models.GitMergeFailResult, error) {
+	if len(refs) < 2 {
+		return nil, nil
 	}
-	return nil, finalFail
+	ref, fail := r.mergeInTwoPhases(ctx, "bisect", refs)
+	if ref != nil {
+		return nil, nil
+	}
+	var mergeFail *models.GitMergeFailResult
+	if errors.As(fail, &mergeFail) {
+		return mergeFail, nil
+	}
+	return nil, fail
 }
 
 // doMerge performs the actual merge operation
-func (r *Repo) doMerge(message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitRef, error) {
+func (r *Repo) doMerge(ctx context.Context, message string, base *models.GitRef, commits ...*models.GitRef) (*models.GitMergeResult, error) {
 	// Reset to base commit
-	if _, err := r.execCommand("git", "checkout", base.Commit); err != nil {
+	if _, err := r.execCommand(ctx, "git", "switch", "--detach", "--end-of-options", base.Commit); err != nil {
 		return nil, err
 	}
 	defer func() {
-		// No matter merge success or not, keep the working directory clean
-		_, _ = r.execCommand("git", "reset", "--hard", "HEAD")
+		// No matter merge success or not, keep the working directory clean, even if the merge was canceled
+		_, _ = r.execCommand(context.WithoutCancel(ctx), "git", "reset", "--hard", "HEAD")
 	}()
+	// Commit with dates derived from the inputs, so that merging the same commits gives the same hash
+	parents := []string{base.Commit}
+	for _, c := range commits {
+		parents = append(parents, c.Commit)
+	}
+	env, err := r.commitEnv(ctx, parents...)
+	if err != nil {
+		return nil, err
+	}
 	// Early return for empty commits
 	if len(commits) == 0 {
-		if _, err := r.execCommand("git", "commit", "--allow-empty", "-m", message); err != nil {
-			return nil, err
-		}
-		hash, err := r.RevParse("HEAD")
-		if err != nil {
+		if _, err := r.execCommandEnv(ctx, env, "git", "commit", "--allow-empty", "-m", message); err != nil {
 			return nil, err
 		}
-		return &models.GitRef{
-			Name:   "HEAD",
-			Commit: hash,
-		}, nil
+		return r.headMergeResult(ctx, nil)
 	}
 
 	// Prepare merge command
-	args := []string{"merge", "--no-ff", "-m", message}
+	args := []string{"merge", "--no-ff", "-m", message, "--end-of-options"}
 	for _, c := range commits {
 		args = append(args, c.Commit)
 	}
 	// Execute merge
-	_, err := r.execCommand("git", args...)
-	if err != nil {
-		// Get conflict details
-		conflicts := []models.FileMergeConflict{}
-		for _, line := range strings.Split(err.Stdout, "\n") {
-			if strings.HasPrefix(line, "CONFLICT ") {
-				// Parse conflict details using git diff
-				parts := strings.SplitN(line, ": ", 2)
-				if len(parts) != 2 {
-					continue
-				}
-				conflictType := strings.Trim(parts[0], "CONFLICT ()")
-				path := strings.TrimSpace(strings.Split(parts[1], " in ")[1])
+	_, fail := r.execCommandEnv(ctx, env, "git", args...)
+	if fail == nil {
+		return r.headMergeResult(ctx, nil)
+	}
 
-				// Get diff for the conflicted file
-				diffRes, err := r.execCommand("git", "diff", path)
-				if err != nil {
-					return nil, err
-				}
+	// Get conflict details
+	conflicts := []models.FileMergeConflict{}
+	for _, line := range strings.Split(fail.Stdout, "\n") {
+		if strings.HasPrefix(line, "CONFLICT ") {
+			// Parse conflict details using git diff
+			parts := strings.SplitN(line, ": ", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			conflictType := strings.Trim(parts[0], "CONFLICT ()")
+			path := strings.TrimSpace(strings.Split(parts[1], " in ")[1])
 
-				conflicts = append(conflicts, models.FileMergeConflict{
-					Path:           path,
-					ConflictType:   conflictType,
-					ConflictDetail: diffRes.Stdout,
-				})
+			// Get diff for the conflicted file
+			diffRes, err := r.execCommand(ctx, "git", "diff", "--", path)
+			if err != nil {
+				return nil, err
 			}
+
+			conflicts = append(conflicts, models.FileMergeConflict{
+				Path:           path,
+				ConflictType:   conflictType,
+				ConflictDetail: diffRes.Stdout,
+			})
 		}
+	}
 
-		return nil, &models.GitMergeFailResult{
-			CommandExecFail: *err,
-			FailedFiles:     conflicts,
+	// Try to resolve the conflicts with merge strategies before giving up
+	resolved, allResolved := r.resolveConflicts(ctx)
+	if allResolved {
+		if _, err := r.execCommandEnv(ctx, env, "git", "commit", "-m", message); err != nil {
+			return nil, err
 		}
+		return r.headMergeResult(ctx, resolved)
 	}
+	unresolved := make([]models.FileMergeConflict, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		if !slices.ContainsFunc(resolved, func(res models.FileAutoResolution) bool { return res.Path == conflict.Path }) {
+			unresolved = append(unresolved, conflict)
+		}
+	}
+
+	return nil, &models.GitMergeFailResult{
+		CommandExecFail: *fail,
+		FailedFiles:     unresolved,
+	}
+}
 
-	// Get resulting commit
-	hash, parseErr := r.RevParse("HEAD")
-	if parseErr != nil {
-		return nil, parseErr
+// commitEnv returns the environment variables fixing the author and committer dates of a new commit
+// to the latest committer date of the given commits, and passing the date to the OpenPGP signing program
+// as BB_COMMIT_TIME
+func (r *Repo) commitEnv(ctx context.Context, commits ...string) ([]string, error) {
+	args := append([]string{"log", "--no-walk", "--format=%ct", "--end-of-options"}, commits...)
+	res, err := r.execCommand(ctx, "git", args...)
+	if err != nil {
+		return nil, err
+	}
+	var latest int64
+	for _, line := range strings.Fields(res.Stdout) {
+		if t, err := strconv.ParseInt(line, 10, 64); err == nil && t > latest {
+			latest = t
+		}
 	}
+	date := fmt.Sprintf("@%d +0000", latest)
+	return []string{"GIT_AUTHOR_DATE=" + date, "GIT_COMMITTER_DATE=" + date, fmt.Sprintf("BB_COMMIT_TIME=%d", latest)}, nil
+}
 
-	return &models.GitRef{
-		Name:   "HEAD",
-		Commit: hash,
+// headMergeResult returns the merge result pointing to the current HEAD
+func (r *Repo) headMergeResult(ctx context.Context, autoResolved []models.FileAutoResolution) (*models.GitMergeResult, error) {
+	hash, err := r.RevParse(ctx, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	return &models.GitMergeResult{
+		GitRef: models.GitRef{
+			Name:   "HEAD",
+			Commit: hash,
+		},
+		AutoResolved: autoResolved,
 	}, nil
 }
 
-// checkConflict checks if two branches have conflicts
-func (r *Repo) checkConflict(base, other *models.GitRef) bool {
-	// Reset to base commit
-	if _, err := r.execCommand("git", "reset", "--hard", base.Commit); err != nil {
-		return false
+// RewordCommit creates a copy of commit with the same tree and parents but a different message,
+// returning the hash of the new commit
+func (r *Repo) RewordCommit(ctx context.Context, commit, message string) (string, error) {
+	if err := CheckRevision(commit); err != nil {
+		return "", err
+	}
+	res, err := r.execCommand(ctx, "git", "rev-list", "--parents", "-n", "1", "--end-of-options", commit)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", "-m", message}
+	if r.opts.Signer != nil {
+		// unlike commit and merge, commit-tree only signs when asked explicitly
+		args = append(args, "-S")
+	}
+	for _, parent := range strings.Fields(res.Stdout)[1:] {
+		args = append(args, "-p", parent)
+	}
+	args = append(args, "--end-of-options", commit+"^{tree}")
+	env, envErr := r.commitEnv(ctx, commit)
+	if envErr != nil {
+		return "", envErr
 	}
+	res, err = r.execCommandEnv(ctx, env, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// validationEnvVars are the only variables of the bot's environment passed to validation commands and
+// resolver commands of merge strategies, which must not see credentials like BB_GITLAB_TOKEN
+var validationEnvVars = []string{"PATH", "HOME", "LANG"}
 
-	// Try merge without committing
-	if _, err := r.execCommand("git", "merge", "--no-ff", "--no-commit", other.Commit); err != nil {
-		// Clean up
-		_, _ = r.execCommand("git", "merge", "--abort") // Ignore cleanup errors
-		return true
+// validationEnv returns the environment of validation commands and resolver commands of merge strategies
+func validationEnv() []string {
+	env := make([]string, 0, len(validationEnvVars))
+	for _, name := range validationEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
 	}
+	return env
+}
+
+// Validate runs validation commands with sh in a temporary worktree checked out at commit,
+// returning the first failed validation. All commands share the timeout.
+func (r *Repo) Validate(ctx context.Context, commit string, commands []string, timeout time.Duration) error {
+	if err := CheckRevision(commit); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "bb-validate-*")
+	if err != nil {
+		return fmt.Errorf("failed to create validation directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	worktree := filepath.Join(tmpDir, "worktree")
+	if err := r.execCommandError(ctx, "git", "worktree", "add", "--detach", worktree, "--end-of-options", commit); err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = r.execCommand(context.WithoutCancel(ctx), "git", "worktree", "remove", "--force", worktree)
+	}()
 
-	// Clean up
-	_, _ = r.execCommand("git", "merge", "--abort") // Ignore cleanup errors
-	return false
+	validateCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for _, command := range commands {
+		cmd := exec.CommandContext(validateCtx, "sh", "-c", command)
+		cmd.Dir = worktree
+		cmd.Env = validationEnv()
+		killProcessGroupOnCancel(cmd)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			status := err.Error()
+			switch {
+			case ctx.Err() != nil:
+				status = fmt.Sprintf("aborted: %s", ctx.Err())
+			case errors.Is(validateCtx.Err(), context.DeadlineExceeded):
+				status = fmt.Sprintf("timed out after %s", timeout)
+			}
+			res := &models.CommandExecResult{
+				Cmdline: command,
+				Stdout:  stdout.String(),
+				Stderr:  stderr.String(),
+				Status:  status,
+			}
+			// the output ends up in issues, keep credentials out of it
+			res.Redact(r.opts.secrets()...)
+			return &models.ValidationFail{CommandExecFail: models.CommandExecFail(*res)}
+		}
+	}
+	return nil
 }
 
 // GetCommitMessage returns the commit message for the given commit
-func (r *Repo) GetCommitMessage(commit string) (string, error) {
-	res, err := r.execCommand("git", "log", "-1", "--pretty=format:%B", commit)
+func (r *Repo) GetCommitMessage(ctx context.Context, commit string) (string, error) {
+	if err := CheckRevision(commit); err != nil {
+		return "", err
+	}
+	res, err := r.execCommand(ctx, "git", "log", "-1", "--pretty=format:%B", "--end-of-options", commit)
 	if err != nil {
 		return "", err
 	}
 	return res.Stdout, nil
 }
 
+// IsAncestor reports whether ancestor is reachable from commit
+func (r *Repo) IsAncestor(ctx context.Context, ancestor, commit string) (bool, error) {
+	if err := checkRevisions(ancestor, commit); err != nil {
+		return false, err
+	}
+	res, err := r.execCommand(ctx, "git", "rev-list", "--count", "--end-of-options", ancestor, "^"+commit)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(res.Stdout) == "0", nil
+}
+
+// AheadBehind counts the commits reachable from commit but not from base, and the other way around
+func (r *Repo) AheadBehind(ctx context.Context, commit, base string) (ahead, behind int, err error) {
+	if err := checkRevisions(commit, base); err != nil {
+		return 0, 0, err
+	}
+	res, fail := r.execCommand(ctx, "git", "rev-list", "--left-right", "--count", "--end-of-options", commit+"..."+base)
+	if fail != nil {
+		return 0, 0, fail
+	}
+	if _, err := fmt.Sscanf(res.Stdout, "%d\t%d", &ahead, &behind); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse commit counts %q: %w", res.Stdout, err)
+	}
+	return ahead, behind, nil
+}
+
+// Log returns the commits reachable from to but not from from, latest first, at most limit of them
+func (r *Repo) Log(ctx context.Context, from, to string, limit int) ([]models.CommitSummary, error) {
+	if err := checkRevisions(from, to); err != nil {
+		return nil, err
+	}
+	res, err := r.execCommand(ctx, "git", "log", "-z", "--format=%H%x09%s", fmt.Sprintf("--max-count=%d", limit),
+		"--end-of-options", from+".."+to, "--")
+	if err != nil {
+		return nil, err
+	}
+	var commits []models.CommitSummary
+	for _, line := range strings.Split(res.Stdout, "\x00") {
+		sha, title, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		commits = append(commits, models.CommitSummary{SHA: sha, Title: title})
+	}
+	return commits, nil
+}
+
+// CommitTime returns the committer date of commit
+func (r *Repo) CommitTime(ctx context.Context, commit string) (time.Time, error) {
+	if err := CheckRevision(commit); err != nil {
+		return time.Time{}, err
+	}
+	res, fail := r.execCommand(ctx, "git", "log", "-1", "--format=%ct", "--end-of-options", commit)
+	if fail != nil {
+		return time.Time{}, fail
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(res.Stdout), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse commit time %q: %w", res.Stdout, err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+// MergeBase returns the best common ancestor of commit and a hypothetical merge of the others
+func (r *Repo) MergeBase(ctx context.Context, commit string, others ...string) (string, error) {
+	commits := append([]string{commit}, others...)
+	if err := checkRevisions(commits...); err != nil {
+		return "", err
+	}
+	args := append([]string{"merge-base", "--end-of-options"}, commits...)
+	res, err := r.execCommand(ctx, "git", args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(res.Stdout), nil
+}
+
+// DiffStat returns the lines changed per file between two commits
+func (r *Repo) DiffStat(ctx context.Context, from, to string) ([]models.FileDiffStat, error) {
+	if err := checkRevisions(from, to); err != nil {
+		return nil, err
+	}
+	res, err := r.execCommand(ctx, "git", "diff", "--numstat", "--no-renames", "-z", "--end-of-options", from, to, "--")
+	if err != nil {
+		return nil, err
+	}
+	var stats []models.FileDiffStat
+	for _, line := range strings.Split(res.Stdout, "\x00") {
+		// each line looks like "<added>\t<deleted>\t<path>", with "-" as counts of binary files
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		stat := models.FileDiffStat{Path: fields[2], Binary: fields[0] == "-"}
+		stat.Added, _ = strconv.Atoi(fields[0])
+		stat.Deleted, _ = strconv.Atoi(fields[1])
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
 // EnsureBranch ensures a branch exists and points to the specified commit.
 // If the commit is empty, the branch will be deleted.
 // If the branch doesn't exist, it will be created.
 // If the branch exists but points to a different commit, it will be updated.
-func (r *Repo) EnsureBranch(name string, commit string) error {
+func (r *Repo) EnsureBranch(ctx context.Context, name string, commit string) error {
+	if err := CheckBranchName(name); err != nil {
+		return err
+	}
 	if commit == "" {
-		return r.execCommandError("git", "branch", "-D", name)
+		return r.execCommandError(ctx, "git", "branch", "-D", "--end-of-options", name)
+	} else if err := CheckRevision(commit); err != nil {
+		return err
 	} else {
-		return r.execCommandError("git", "branch", "-f", name, commit)
+		return r.execCommandError(ctx, "git", "branch", "-f", "--end-of-options", name, commit)
 	}
 }
 
 // EnsureRemote ensures a remote exists and points to the specified URL
-func (r *Repo) EnsureRemote(name string, url string) error {
-	if res, err := r.execCommand("git", "remote", "get-url", name); err != nil {
+func (r *Repo) EnsureRemote(ctx context.Context, name string, url string) error {
+	if res, err := r.execCommand(ctx, "git", "remote", "get-url", "--end-of-options", name); err != nil {
 		if strings.Contains(err.Stderr, "No such remote") {
-			return r.execCommandError("git", "remote", "add", name, url)
+			return r.execCommandError(ctx, "git", "remote", "add", "--end-of-options", name, url)
 		} else {
 			return err
 		}
 	} else if strings.TrimSpace(res.Stdout) != url {
-		return r.execCommandError("git", "remote", "set-url", name, url)
+		return r.execCommandError(ctx, "git", "remote", "set-url", "--end-of-options", name, url)
 	}
 	return nil
 }
 
 // RefreshRemote fetches the latest changes from the remote repository
-func (r *Repo) RefreshRemote() error {
-	return r.execCommandError("git", "fetch", "--all")
+func (r *Repo) RefreshRemote(ctx context.Context) error {
+	_, err := withTimeout(ctx, "fetch", r.opts.Timeouts.Fetch, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, r.execCommandError(ctx, "git", "fetch", "--all")
+	})
+	return err
 }
 
-// PushRemote update a remote branch to a specified commit
-func (r *Repo) PushRemote(remote, branch, commit string) error {
-	return r.execCommandError("git", "push", "-f", remote, fmt.Sprintf("%s:refs/heads/%s", commit, branch))
+// Fetch fetches refs from origin, refs missing on the remote are skipped.
+//
+// Full ref names like refs/heads/main or refs/merge-requests/1/head are stored as remote-tracking refs
+// under refs/remotes/origin, e.g. refs/remotes/origin/main. Anything else is fetched as a commit id.
+func (r *Repo) Fetch(ctx context.Context, refs ...string) error {
+	if err := checkRevisions(refs...); err != nil {
+		return err
+	}
+	refspecs := make([]string, 0, len(refs))
+	for _, ref := range slices.Compact(slices.Sorted(slices.Values(refs))) {
+		refspecs = append(refspecs, fetchRefspec(ref))
+	}
+	if len(refspecs) == 0 {
+		return nil
+	}
+	_, err := withTimeout(ctx, "fetch", r.opts.Timeouts.Fetch, func(ctx context.Context) (struct{}, error) {
+		fetch := func(refspecs ...string) *models.CommandExecFail {
+			args := append([]string{"fetch", "--no-tags", "--end-of-options", "origin"}, refspecs...)
+			_, fail := r.execCommand(ctx, "git", args...)
+			return fail
+		}
+		fail := fetch(refspecs...)
+		if fail == nil || !isMissingRemoteRef(fail) {
+			return struct{}{}, errorOrNil(fail)
+		}
+		// One missing ref fails the whole fetch, fetch the refs one by one to skip the missing ones
+		for _, refspec := range refspecs {
+			if fail := fetch(refspec); fail != nil && !isMissingRemoteRef(fail) {
+				return struct{}{}, fail
+			}
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+// fetchRefspec returns the refspec fetching ref into its remote-tracking ref
+func fetchRefspec(ref string) string {
+	if name, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+		return fmt.Sprintf("+%s:refs/remotes/origin/%s", ref, name)
+	}
+	if name, ok := strings.CutPrefix(ref, "refs/"); ok {
+		return fmt.Sprintf("+%s:refs/remotes/origin/%s", ref, name)
+	}
+	return ref
+}
+
+// isMissingRemoteRef reports whether fetching failed because a ref doesn't exist on the remote
+func isMissingRemoteRef(fail *models.CommandExecFail) bool {
+	return strings.Contains(fail.Stderr, "couldn't find remote ref") ||
+		strings.Contains(fail.Stderr, "not our ref")
+}
+
+// errorOrNil converts fail to error, keeping a nil fail nil
+func errorOrNil(fail *models.CommandExecFail) error {
+	if fail == nil {
+		return nil
+	}
+	return fail
+}
+
+// PushRemote update a remote branch to a specified commit, or deletes it if commit is empty
+func (r *Repo) PushRemote(ctx context.Context, remote, branch, commit string) error {
+	if err := CheckBranchName(branch); err != nil {
+		return err
+	}
+	if commit != "" {
+		if err := CheckRevision(commit); err != nil {
+			return err
+		}
+	}
+	_, err := withTimeout(ctx, "push", r.opts.Timeouts.Push, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, r.execCommandError(ctx, "git", "push", "-f", "--end-of-options", remote, fmt.Sprintf("%s:refs/heads/%s", commit, branch))
+	})
+	return err
+}
+
+// Heal cleans up what interrupted commands leave behind in the repository, like lock files, unfinished
+// merges and worktrees, returning an error if the repository is still broken afterwards.
+// No other command may run on the repository at the same time.
+func (r *Repo) Heal(ctx context.Context) error {
+	gitDir := filepath.Join(r.path, ".git")
+	err := filepath.WalkDir(gitDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path == filepath.Join(gitDir, "objects") {
+			return filepath.SkipDir
+		}
+		// nothing else runs on the repository, so any lock file is left behind by a killed git
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".lock") {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove lock files: %w", err)
+	}
+	for _, args := range [][]string{
+		{"reset", "--hard", "--quiet"}, // also aborts unfinished merges
+		{"clean", "-ffdx", "--quiet"},
+		{"worktree", "prune"},
+	} {
+		if err := r.execCommandError(ctx, "git", args...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Config set a git config in the repository
-func (r *Repo) Config(key, value string) error {
-	return r.execCommandError("git", "config", "--local", key, value)
+func (r *Repo) Config(ctx context.Context, key, value string) error {
+	return r.execCommandError(ctx, "git", "config", "--local", key, value)
 }
 
 // Path returns the absolute path to the repository
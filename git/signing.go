@@ -0,0 +1,167 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Signing formats supported by Signer
+const (
+	SigningFormatOpenPGP = "openpgp"
+	SigningFormatSSH     = "ssh"
+)
+
+// Identity is the name and email recorded as the author and committer of new commits
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// Signer signs new commits with a private key loaded from a file, the key must not have a passphrase.
+//
+// SSH keys are copied with writePrivateKey, OpenPGP keys are imported into a keyring of their own
+// in a private directory owned by the signer.
+//
+// Signatures keep bb commits deterministic: SSH signatures have no timestamp, and OpenPGP signatures are
+// made by a gpg wrapper pinning the signing time to the commit time, or the key creation if later.
+// Like SSH signatures, OpenPGP signatures are only reproducible with Ed25519 or RSA keys.
+type Signer struct {
+	format string
+	dir    string // private directory holding the key or the keyring
+	key    string // path of the ssh key, or fingerprint of the OpenPGP key
+}
+
+// NewSigner loads the private key in keyFile for signing commits in format, openpgp or ssh
+func NewSigner(ctx context.Context, format, keyFile string) (*Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "bb-signing-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing directory: %w", err)
+	}
+	s := &Signer{format: format, dir: dir}
+	switch format {
+	case SigningFormatSSH:
+		err = s.loadSSHKey(ctx, data)
+	case SigningFormatOpenPGP:
+		err = s.loadOpenPGPKey(ctx, data)
+	default:
+		err = fmt.Errorf("unknown signing format %q, expected %s or %s", format, SigningFormatOpenPGP, SigningFormatSSH)
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadSSHKey copies the key into the signer's directory and checks it can be used
+func (s *Signer) loadSSHKey(ctx context.Context, data []byte) error {
+	key, err := writePrivateKey(s.dir, data)
+	if err != nil {
+		return err
+	}
+	s.key = key
+	if output, err := exec.CommandContext(ctx, "ssh-keygen", "-y", "-P", "", "-f", s.key).CombinedOutput(); err != nil {
+		return fmt.Errorf("invalid ssh signing key: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// loadOpenPGPKey imports the key into a keyring in the signer's directory and looks up its fingerprint
+func (s *Signer) loadOpenPGPKey(ctx context.Context, data []byte) error {
+	gpg := func(args ...string) *exec.Cmd {
+		return exec.CommandContext(ctx, "gpg", append([]string{"--batch", "--homedir", s.dir}, args...)...)
+	}
+	cmd := gpg("--import")
+	cmd.Stdin = bytes.NewReader(data)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("invalid openpgp signing key: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	output, err := gpg("--list-secret-keys", "--with-colons").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list openpgp keys: %w", err)
+	}
+	// the fingerprint of the primary key follows its sec record, the creation time is the 6th field
+	// of the sec and ssb records
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	inSecretKey := false
+	var created int64
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		switch {
+		case (fields[0] == "sec" || fields[0] == "ssb") && len(fields) > 5:
+			inSecretKey = true
+			if t, err := strconv.ParseInt(fields[5], 10, 64); err == nil && t > created {
+				created = t
+			}
+		case fields[0] == "fpr" && inSecretKey && s.key == "" && len(fields) > 9:
+			s.key = fields[9]
+		}
+	}
+	if s.key == "" {
+		return fmt.Errorf("no openpgp secret key found in signing key file")
+	}
+	return s.writeGPGProgram(created)
+}
+
+// writeGPGProgram writes the gpg wrapper signing at the commit time passed by commitEnv, but not before
+// the key was created, which would make the signature invalid
+func (s *Signer) writeGPGProgram(keyCreated int64) error {
+	gpg, err := exec.LookPath("gpg")
+	if err != nil {
+		return fmt.Errorf("failed to find gpg: %w", err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+time="$BB_COMMIT_TIME"
+if [ -z "$time" ]; then
+	exec '%[1]s' "$@"
+fi
+if [ "$time" -lt %[2]d ]; then
+	time=%[2]d
+fi
+exec '%[1]s' --faked-system-time "$time!" "$@"
+`, gpg, keyCreated)
+	if err := os.WriteFile(filepath.Join(s.dir, "gpg"), []byte(script), 0700); err != nil {
+		return fmt.Errorf("failed to write gpg program: %w", err)
+	}
+	return nil
+}
+
+// Close removes the copy of the key, stopping the gpg agent started for the keyring
+func (s *Signer) Close() error {
+	if s.format == SigningFormatOpenPGP {
+		_ = exec.Command("gpgconf", "--homedir", s.dir, "--kill", "gpg-agent").Run()
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// gitArgs returns the config options signing every commit
+func (s *Signer) gitArgs() []string {
+	args := []string{
+		"-c", "commit.gpgSign=true",
+		"-c", "gpg.format=" + s.format,
+		"-c", "user.signingKey=" + s.key,
+	}
+	if s.format == SigningFormatOpenPGP {
+		args = append(args, "-c", "gpg.program="+filepath.Join(s.dir, "gpg"))
+	}
+	return args
+}
+
+// gitEnv returns the environment variables pointing gpg to the keyring
+func (s *Signer) gitEnv() []string {
+	if s.format != SigningFormatOpenPGP {
+		return nil
+	}
+	return []string{"GNUPGHOME=" + s.dir}
+}
@@ -0,0 +1,97 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner(t *testing.T) {
+	ctx := context.Background()
+	keyDir := t.TempDir()
+	run := func(name string, args ...string) string {
+		output, err := exec.Command(name, args...).CombinedOutput()
+		require.NoError(t, err, string(output))
+		return string(output)
+	}
+
+	sshKey := filepath.Join(keyDir, "id_ed25519")
+	run("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "bb@example.com", "-f", sshKey)
+	// mounted secrets are often readable by others, which ssh-keygen refuses
+	require.NoError(t, os.Chmod(sshKey, 0644))
+	publicKey, err := os.ReadFile(sshKey + ".pub")
+	require.NoError(t, err)
+	allowedSigners := filepath.Join(keyDir, "allowed_signers")
+	require.NoError(t, os.WriteFile(allowedSigners, []byte("bb@example.com "+string(publicKey)), 0644))
+
+	gnupgHome := filepath.Join(keyDir, "gnupg")
+	require.NoError(t, os.Mkdir(gnupgHome, 0700))
+	run("gpg", "--batch", "--homedir", gnupgHome, "--passphrase", "", "--quick-gen-key", "bb <bb@example.com>", "ed25519", "sign", "never")
+	gpgKey := filepath.Join(keyDir, "key.asc")
+	require.NoError(t, os.WriteFile(gpgKey, []byte(run("gpg", "--batch", "--homedir", gnupgHome, "--armor", "--export-secret-keys")), 0644))
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--homedir", gnupgHome, "--kill", "gpg-agent").Run() })
+
+	for _, tc := range []struct {
+		format    string
+		keyFile   string
+		signature string
+		verify    []string
+	}{
+		{SigningFormatSSH, sshKey, "-----BEGIN SSH SIGNATURE-----", []string{"-c", "gpg.ssh.allowedSignersFile=" + allowedSigners}},
+		{SigningFormatOpenPGP, gpgKey, "-----BEGIN PGP SIGNATURE-----", nil},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			signer, err := NewSigner(ctx, tc.format, tc.keyFile)
+			require.NoError(t, err)
+			defer signer.Close()
+
+			repo := NewTestRepo(t)
+			repo.opts.Identity = &Identity{Name: "bb", Email: "bb@example.com"}
+			repo.opts.Signer = signer
+			baseHash, err := repo.RevParse(ctx, "HEAD")
+			require.NoError(t, err)
+			base := &models.GitRef{Name: "main", Commit: baseHash}
+			feature1 := repo.CreateBranch(base, "feature1", "file1.txt", "feature1 content")
+			feature2 := repo.CreateBranch(base, "feature2", "file2.txt", "feature2 content")
+
+			result, err := repo.Merge(ctx, "Merge feature1 and feature2", feature1, feature2)
+			require.NoError(t, err)
+			reworded, err := repo.RewordCommit(ctx, result.Commit, "Reworded merge")
+			require.NoError(t, err)
+			// signed commits are deterministic as well, the signing time doesn't change them
+			time.Sleep(1100 * time.Millisecond)
+			again, err := repo.Merge(ctx, "Merge feature1 and feature2", feature1, feature2)
+			require.NoError(t, err)
+			assert.Equal(t, result.Commit, again.Commit)
+			for _, commit := range []string{result.Commit, reworded} {
+				res, fail := repo.execCommand(ctx, "git", "cat-file", "commit", commit)
+				require.Nil(t, fail)
+				assert.Contains(t, res.Stdout, tc.signature)
+				_, fail = repo.execCommand(ctx, "git", append(tc.verify, "verify-commit", commit)...)
+				assert.Nil(t, fail)
+				res, fail = repo.execCommand(ctx, "git", "log", "-1", "--format=%an <%ae> %cn <%ce>", commit)
+				require.Nil(t, fail)
+				assert.Equal(t, "bb <bb@example.com> bb <bb@example.com>", strings.TrimSpace(res.Stdout))
+			}
+		})
+	}
+
+	t.Run("invalid key", func(t *testing.T) {
+		for _, format := range []string{SigningFormatSSH, SigningFormatOpenPGP} {
+			_, err := NewSigner(ctx, format, sshKey+".pub")
+			assert.Error(t, err, format)
+		}
+		_, err := NewSigner(ctx, "x509", sshKey)
+		assert.ErrorContains(t, err, "unknown signing format")
+		_, err = NewSigner(ctx, SigningFormatSSH, filepath.Join(keyDir, "missing"))
+		assert.ErrorContains(t, err, "failed to read signing key")
+	})
+}
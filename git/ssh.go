@@ -0,0 +1,73 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SSHTransport authenticates with remotes over ssh with a deploy key, verifying host keys against
+// a known_hosts file. The key must not have a passphrase, it's copied with writePrivateKey.
+type SSHTransport struct {
+	dir        string // private directory holding the key
+	key        string
+	knownHosts string
+}
+
+// NewSSHTransport loads the deploy key in keyFile, host keys are verified against knownHostsFile
+func NewSSHTransport(keyFile, knownHostsFile string) (*SSHTransport, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh key: %w", err)
+	}
+	if _, err := os.Stat(knownHostsFile); err != nil {
+		return nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	knownHosts, err := filepath.Abs(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "bb-ssh-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ssh directory: %w", err)
+	}
+	t := &SSHTransport{dir: dir, knownHosts: knownHosts}
+	if t.key, err = writePrivateKey(dir, data); err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// writePrivateKey copies a private key into the private directory dir, returning the path of the copy.
+// The copy is only readable by the owner, as ssh and ssh-keygen refuse keys readable by others,
+// so the key file itself may be a read-only mounted secret.
+func writePrivateKey(dir string, data []byte) (string, error) {
+	key := filepath.Join(dir, "key")
+	if err := os.WriteFile(key, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write private key: %w", err)
+	}
+	return key, nil
+}
+
+// Close removes the copy of the key
+func (t *SSHTransport) Close() error {
+	return os.RemoveAll(t.dir)
+}
+
+// gitEnv returns the environment variables making git run ssh with the deploy key only,
+// never prompting and refusing unknown hosts
+func (t *SSHTransport) gitEnv() []string {
+	command := strings.Join([]string{
+		"ssh",
+		"-i", shellQuote(t.key),
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityAgent=none",
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", shellQuote("UserKnownHostsFile=" + t.knownHosts),
+		"-o", "GlobalKnownHostsFile=/dev/null",
+	}, " ")
+	return []string{"GIT_SSH_COMMAND=" + command}
+}
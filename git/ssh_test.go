@@ -0,0 +1,82 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSHTransport(t *testing.T) {
+	ctx := context.Background()
+	remote := NewTestRepo(t)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "deploy_key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("private key"), 0644))
+	knownHosts := filepath.Join(dir, "known hosts")
+	require.NoError(t, os.WriteFile(knownHosts, []byte("gitlab.example.com ssh-ed25519 AAAA"), 0644))
+
+	// a fake ssh logging its arguments and running the remote command locally
+	sshLog := filepath.Join(dir, "ssh.log")
+	binDir := filepath.Join(dir, "bin")
+	require.NoError(t, os.Mkdir(binDir, 0755))
+	fakeSSH := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\nfor last; do :; done\nexec sh -c \"$last\"\n", shellQuote(sshLog))
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "ssh"), []byte(fakeSSH), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	transport, err := NewSSHTransport(keyFile, knownHosts)
+	require.NoError(t, err)
+	defer transport.Close()
+	info, err := os.Stat(transport.key)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	repoPath := filepath.Join(t.TempDir(), "project")
+	repo, err := SyncRepo(ctx, repoPath, remote.Path(), Options{})
+	require.NoError(t, err)
+	assert.NoFileExists(t, sshLog)
+
+	t.Run("switch to ssh", func(t *testing.T) {
+		sshUrl := "git@gitlab.example.com:" + remote.Path()
+		repo, err = SyncRepo(ctx, repoPath, sshUrl, Options{SSH: transport})
+		require.NoError(t, err)
+		res, fail := repo.execCommand(ctx, "git", "remote", "get-url", "origin")
+		require.Nil(t, fail)
+		assert.Equal(t, sshUrl+"\n", res.Stdout)
+
+		require.NoError(t, repo.Fetch(ctx, "refs/heads/main"))
+		head, err := repo.RevParse(ctx, "refs/remotes/origin/main")
+		require.NoError(t, err)
+		require.NoError(t, repo.PushRemote(ctx, "origin", "pushed-over-ssh", head))
+		pushed, err := remote.RevParse(ctx, "pushed-over-ssh")
+		require.NoError(t, err)
+		assert.Equal(t, head, pushed)
+
+		log, err := os.ReadFile(sshLog)
+		require.NoError(t, err)
+		assert.Contains(t, string(log), "-i "+transport.key)
+		assert.Contains(t, string(log), "StrictHostKeyChecking=yes")
+		assert.Contains(t, string(log), "UserKnownHostsFile="+knownHosts)
+		assert.Contains(t, string(log), "git@gitlab.example.com git-upload-pack")
+		assert.Contains(t, string(log), "git@gitlab.example.com git-receive-pack")
+	})
+
+	t.Run("switch back", func(t *testing.T) {
+		repo, err = SyncRepo(ctx, repoPath, remote.Path(), Options{})
+		require.NoError(t, err)
+		res, fail := repo.execCommand(ctx, "git", "remote", "get-url", "origin")
+		require.Nil(t, fail)
+		assert.Equal(t, remote.Path()+"\n", res.Stdout)
+	})
+
+	t.Run("missing files", func(t *testing.T) {
+		_, err := NewSSHTransport(filepath.Join(dir, "missing"), knownHosts)
+		assert.ErrorContains(t, err, "failed to read ssh key")
+		_, err = NewSSHTransport(keyFile, filepath.Join(dir, "missing"))
+		assert.ErrorContains(t, err, "failed to read known hosts")
+	})
+}
@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -56,6 +57,7 @@ func (r *TestRepo) CreateBranch(base *models.GitRef, name, file, content string)
 	r.mustExec("git", "checkout", base.Commit, "-b", name)
 
 	// Create a file
+	require.NoError(r.t, os.MkdirAll(filepath.Dir(filepath.Join(r.path, file)), 0755))
 	f, err := os.Create(filepath.Join(r.path, file))
 	require.NoError(r.t, err)
 	_, err = f.WriteString(content)
@@ -68,7 +70,7 @@ func (r *TestRepo) CreateBranch(base *models.GitRef, name, file, content string)
 	r.mustExec("git", "commit", "-m", "Add "+file)
 
 	// Get commit hash
-	hash, err := r.RevParse("HEAD")
+	hash, err := r.RevParse(context.Background(), "HEAD")
 	require.NoError(r.t, err)
 
 	return &models.GitRef{
@@ -81,6 +83,7 @@ func (r *TestRepo) UpdateBranch(name, file, content string) *models.GitRef {
 	r.mustExec("git", "checkout", name)
 
 	// Create a file
+	require.NoError(r.t, os.MkdirAll(filepath.Dir(filepath.Join(r.path, file)), 0755))
 	f, err := os.Create(filepath.Join(r.path, file))
 	require.NoError(r.t, err)
 	_, err = f.WriteString(content)
@@ -93,7 +96,31 @@ func (r *TestRepo) UpdateBranch(name, file, content string) *models.GitRef {
 	r.mustExec("git", "commit", "-m", "Update"+file)
 
 	// Get commit hash
-	commit, err := r.RevParse("HEAD")
+	commit, err := r.RevParse(context.Background(), "HEAD")
+	require.NoError(r.t, err)
+	return &models.GitRef{
+		Name:   name,
+		Commit: commit,
+	}
+}
+
+// ResolveConflict creates a branch from base that merges other, resolving any conflict by
+// writing content to file
+func (r *TestRepo) ResolveConflict(base, other *models.GitRef, name, file, content string) *models.GitRef {
+	r.mustExec("git", "checkout", base.Commit, "-b", name)
+
+	// Merge may fail with conflicts, which are resolved below
+	_, _ = r.execCommand(context.Background(), "git", "merge", "--no-ff", "--no-commit", other.Commit)
+	f, err := os.Create(filepath.Join(r.path, file))
+	require.NoError(r.t, err)
+	_, err = f.WriteString(content)
+	require.NoError(r.t, err)
+	f.Close()
+
+	r.mustExec("git", "add", file)
+	r.mustExec("git", "commit", "-m", "Resolve conflicts between "+base.Name+" and "+other.Name)
+
+	commit, err := r.RevParse(context.Background(), "HEAD")
 	require.NoError(r.t, err)
 	return &models.GitRef{
 		Name:   name,
@@ -102,7 +129,7 @@ func (r *TestRepo) UpdateBranch(name, file, content string) *models.GitRef {
 }
 
 func (r *TestRepo) mustExec(name string, args ...string) {
-	_, err := r.execCommand(name, args...)
+	_, err := r.execCommand(context.Background(), name, args...)
 	if err != nil {
 		r.t.Fatal(err)
 	}
@@ -0,0 +1,176 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// apiErrorClass classifies errors of GitLab API calls by what can be done about them
+type apiErrorClass int
+
+const (
+	// apiErrorFatal errors won't go away by retrying
+	apiErrorFatal apiErrorClass = iota
+	// apiErrorNotFound errors are lookups of things that don't exist, e.g. deleted branches
+	apiErrorNotFound
+	// apiErrorAuth errors are calls the bot user isn't allowed to make
+	apiErrorAuth
+	// apiErrorTransient errors are rate limits, server errors and network failures, which may succeed later
+	apiErrorTransient
+)
+
+func (c apiErrorClass) String() string {
+	switch c {
+	case apiErrorNotFound:
+		return "not found"
+	case apiErrorAuth:
+		return "auth"
+	case apiErrorTransient:
+		return "transient"
+	default:
+		return "fatal"
+	}
+}
+
+// classifyAPIError returns the class of an error returned by a GitLab API call
+func classifyAPIError(err error) apiErrorClass {
+	if errors.Is(err, gitlab.ErrNotFound) {
+		return apiErrorNotFound
+	}
+	var errResp *gitlab.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		return classifyStatus(errResp.Response.StatusCode)
+	}
+	if isTransientNetworkError(err) {
+		return apiErrorTransient
+	}
+	return apiErrorFatal
+}
+
+// classifyStatus returns the class of an error response by its status code
+func classifyStatus(status int) apiErrorClass {
+	switch {
+	case status == http.StatusNotFound:
+		return apiErrorNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return apiErrorAuth
+	case status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500:
+		return apiErrorTransient
+	default:
+		return apiErrorFatal
+	}
+}
+
+// isTransientNetworkError tells if a request failed on the way to GitLab in a way that may not happen again.
+// Canceled requests are not, they are given up on by the caller.
+func isTransientNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// describeAPIError describes a failed lookup of what, e.g. "branch feature-a", by the class of its error
+func describeAPIError(what string, err error) error {
+	switch classifyAPIError(err) {
+	case apiErrorNotFound:
+		return fmt.Errorf("%s not found: %w", what, err)
+	case apiErrorAuth:
+		return fmt.Errorf("no permission to get %s: %w", what, err)
+	case apiErrorTransient:
+		return fmt.Errorf("%s is temporarily unavailable: %w", what, err)
+	default:
+		return fmt.Errorf("failed to get %s: %w", what, err)
+	}
+}
+
+// Requests failing with transient errors are retried up to apiRetryMax times, waiting exponentially longer
+// from apiRetryWaitMin up to apiRetryWaitMax. Rate limited requests wait for the rate limit to reset instead,
+// up to apiRateLimitWaitMax.
+const (
+	apiRetryMax         = 5
+	apiRetryWaitMin     = 500 * time.Millisecond
+	apiRetryWaitMax     = 10 * time.Second
+	apiRateLimitWaitMax = time.Minute
+)
+
+// apiClientOptions are the options of the GitLab client retrying transient errors
+func apiClientOptions() []gitlab.ClientOptionFunc {
+	return []gitlab.ClientOptionFunc{
+		gitlab.WithCustomRetry(retryAPICall),
+		gitlab.WithCustomBackoff(apiBackoff),
+		gitlab.WithCustomRetryMax(apiRetryMax),
+		gitlab.WithCustomRetryWaitMinMax(apiRetryWaitMin, apiRetryWaitMax),
+	}
+}
+
+// retryAPICall tells if a request should be retried, which is the case for transient errors. Requests changing
+// things, like posting notes, may have been handled by GitLab even though they failed, so they are only retried
+// if they surely haven't been, that is if they were rate limited or the connection was refused.
+func retryAPICall(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		// the http client reports failed requests as url errors whose Op is the method
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && !isReadOnlyMethod(urlErr.Op) {
+			return errors.Is(err, syscall.ECONNREFUSED), err
+		}
+		return isTransientNetworkError(err), err
+	}
+	if resp.Request != nil && !isReadOnlyMethod(resp.Request.Method) {
+		return resp.StatusCode == http.StatusTooManyRequests, nil
+	}
+	return classifyStatus(resp.StatusCode) == apiErrorTransient, nil
+}
+
+// isReadOnlyMethod tells if requests of an http method only read things, so that they are safe to repeat
+func isReadOnlyMethod(method string) bool {
+	return strings.EqualFold(method, http.MethodGet) || strings.EqualFold(method, http.MethodHead)
+}
+
+// apiBackoff returns how long to wait before retrying a request, honoring the RateLimit-Reset and
+// Retry-After headers of rate limited responses
+func apiBackoff(waitMin, waitMax time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	// jitter keeps retries of concurrent requests from hitting GitLab at the same time again
+	jitter := time.Duration(rand.Int64N(int64(waitMin)))
+	if resp != nil {
+		if wait, ok := rateLimitWait(resp.Header); ok {
+			return min(wait, apiRateLimitWaitMax) + jitter
+		}
+	}
+	wait := waitMin << attemptNum
+	if wait <= 0 || wait > waitMax {
+		wait = waitMax
+	}
+	return wait + jitter
+}
+
+// rateLimitWait returns how long a response asks to wait before making requests again
+func rateLimitWait(header http.Header) (time.Duration, bool) {
+	if reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64); err == nil && reset > 0 {
+		return max(time.Until(time.Unix(reset, 0)), 0), true
+	}
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
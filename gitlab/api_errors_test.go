@@ -0,0 +1,141 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestClassifyAPIError(t *testing.T) {
+	response := func(status int) error {
+		return &gitlab.ErrorResponse{Response: &http.Response{
+			StatusCode: status,
+			Request:    httptest.NewRequest(http.MethodGet, "/api/v4/projects/1", nil),
+		}}
+	}
+	tests := []struct {
+		err  error
+		want apiErrorClass
+	}{
+		{gitlab.ErrNotFound, apiErrorNotFound},
+		{describeAPIError("branch a", gitlab.ErrNotFound), apiErrorNotFound},
+		{response(http.StatusUnauthorized), apiErrorAuth},
+		{response(http.StatusForbidden), apiErrorAuth},
+		{response(http.StatusTooManyRequests), apiErrorTransient},
+		{response(http.StatusBadGateway), apiErrorTransient},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), apiErrorTransient},
+		{response(http.StatusBadRequest), apiErrorFatal},
+		{context.Canceled, apiErrorFatal},
+		{errors.New("unexpected"), apiErrorFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.want, classifyAPIError(tt.err))
+		})
+	}
+}
+
+func TestAPIBackoff(t *testing.T) {
+	header := func(key, value string) *http.Response {
+		return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{key: []string{value}}}
+	}
+	within := func(t *testing.T, got, want time.Duration) {
+		t.Helper()
+		assert.GreaterOrEqual(t, got, want-time.Second)
+		assert.Less(t, got, want+apiRetryWaitMin)
+	}
+
+	reset := strconv.FormatInt(time.Now().Add(20*time.Second).Unix(), 10)
+	within(t, apiBackoff(apiRetryWaitMin, apiRetryWaitMax, 0, header("Ratelimit-Reset", reset)), 20*time.Second)
+	within(t, apiBackoff(apiRetryWaitMin, apiRetryWaitMax, 0, header("Retry-After", "7")), 7*time.Second)
+	// waiting for the rate limit to reset is capped
+	within(t, apiBackoff(apiRetryWaitMin, apiRetryWaitMax, 0, header("Retry-After", "3600")), apiRateLimitWaitMax)
+	// other failures back off exponentially
+	assert.GreaterOrEqual(t, apiBackoff(apiRetryWaitMin, apiRetryWaitMax, 2, nil), 4*apiRetryWaitMin)
+	assert.Less(t, apiBackoff(apiRetryWaitMin, apiRetryWaitMax, 20, nil), apiRetryWaitMax+apiRetryWaitMin)
+}
+
+func TestAPIRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/branches/limited":
+			// rate limited once, then served
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte(`{"name": "limited", "commit": {"id": "0123456789"}}`))
+		case "/api/v4/projects/1/issues/1/notes":
+			// posting a note fails after it may have been posted
+			calls.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		case "/api/v4/projects/1/issues/2/notes":
+			// rate limited notes surely haven't been posted
+			if calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id": 1}`))
+		case "/api/v4/projects/1/repository/branches/forbidden":
+			calls.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	gl, err := gitlab.NewClient("token", append(apiClientOptions(), gitlab.WithBaseURL(server.URL))...)
+	require.NoError(t, err)
+
+	branch, _, err := gl.Branches.GetBranch(1, "limited")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", branch.Commit.ID)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// errors that won't go away are not retried
+	calls.Store(0)
+	_, _, err = gl.Branches.GetBranch(1, "forbidden")
+	assert.Equal(t, apiErrorAuth, classifyAPIError(err))
+	assert.Equal(t, int32(1), calls.Load())
+	_, _, err = gl.Branches.GetBranch(1, "deleted")
+	assert.Equal(t, apiErrorNotFound, classifyAPIError(err))
+	assert.ErrorContains(t, describeAPIError("branch deleted", err), "branch deleted not found")
+
+	// requests changing things are not retried unless they surely failed
+	calls.Store(0)
+	_, _, err = gl.Notes.CreateIssueNote(1, 1, &gitlab.CreateIssueNoteOptions{Body: gitlab.Ptr("done")})
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	calls.Store(0)
+	_, _, err = gl.Notes.CreateIssueNote(1, 2, &gitlab.CreateIssueNoteOptions{Body: gitlab.Ptr("done")})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load())
+	retry := func(method string, err error) bool {
+		ok, _ := retryAPICall(context.Background(), nil, &url.Error{Op: method, URL: "https://gitlab.example.com", Err: err})
+		return ok
+	}
+	assert.True(t, retry("Get", syscall.ECONNRESET))
+	assert.False(t, retry("Post", syscall.ECONNRESET))
+	assert.True(t, retry("Post", syscall.ECONNREFUSED))
+
+	// retries give up with the context of the call
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = gl.Branches.GetBranch(1, "limited", gitlab.WithContext(ctx))
+	assert.ErrorIs(t, err, context.Canceled)
+}
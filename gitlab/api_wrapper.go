@@ -1,7 +1,10 @@
 package gitlab
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/jizhilong/branch-bot/git"
 	"github.com/jizhilong/branch-bot/models"
 	"log/slog"
 	"strconv"
@@ -11,12 +14,39 @@ import (
 )
 
 func (h *Webhook) reply(note *gitlab.IssueCommentEvent, message string) {
-	_, _, err := h.gl.Notes.CreateIssueNote(note.ProjectID, note.Issue.IID, &gitlab.CreateIssueNoteOptions{
-		Body: &message,
-	})
-	if err != nil {
-		slog.Error("Failed to reply to comment", "error", err)
+	if _, err := replyInThread(h.ctx, h.gl, note, message); err != nil {
+		slog.Error("Failed to reply to comment", "error", err, "class", classifyAPIError(err))
+	}
+}
+
+// postResult replies the result of a command in the thread of its comment, returning the link of the reply,
+// or of the comment itself if replying failed
+func (h *Webhook) postResult(ctx context.Context, note *gitlab.IssueCommentEvent, fail error) string {
+	result := "all goes well"
+	if fail != nil {
+		result = fmt.Sprintf("failed to process: %s", h.viewHelper(ctx, note, fail).errorToMarkdown(fail))
+	}
+	reply, err := replyInThread(ctx, h.gl, note, result)
+	if err != nil || reply == nil {
+		slog.Error("Failed to reply command result", "error", err, "class", classifyAPIError(err))
+		return note.ObjectAttributes.URL
+	}
+	// comments link to their issue with the note as fragment
+	issueURL, _, _ := strings.Cut(note.ObjectAttributes.URL, "#")
+	return fmt.Sprintf("%s#note_%d", issueURL, reply.ID)
+}
+
+// replyInThread replies to a comment in its discussion thread, so that results of commands stay next to them
+func replyInThread(ctx context.Context, gl *gitlab.Client, note *gitlab.IssueCommentEvent, message string) (*gitlab.Note, error) {
+	if note.ObjectAttributes.DiscussionID == "" {
+		reply, _, err := gl.Notes.CreateIssueNote(note.ProjectID, note.Issue.IID, &gitlab.CreateIssueNoteOptions{
+			Body: &message,
+		}, gitlab.WithContext(ctx))
+		return reply, err
 	}
+	reply, _, err := gl.Discussions.AddIssueDiscussionNote(note.ProjectID, note.Issue.IID,
+		note.ObjectAttributes.DiscussionID, &gitlab.AddIssueDiscussionNoteOptions{Body: &message}, gitlab.WithContext(ctx))
+	return reply, err
 }
 
 func (h *Webhook) awardEmojiAgainstError(note *gitlab.IssueCommentEvent, err error) {
@@ -30,9 +60,9 @@ func (h *Webhook) awardEmojiAgainstError(note *gitlab.IssueCommentEvent, err err
 func (h *Webhook) awardEmoji(note *gitlab.IssueCommentEvent, emoji string) {
 	_, _, err := h.gl.AwardEmoji.CreateIssuesAwardEmojiOnNote(note.ProjectID, note.Issue.IID,
 		note.ObjectAttributes.ID,
-		&gitlab.CreateAwardEmojiOptions{Name: emoji})
+		&gitlab.CreateAwardEmojiOptions{Name: emoji}, gitlab.WithContext(h.ctx))
 	if err != nil {
-		slog.Error("Failed to award emoji", "error", err)
+		slog.Error("Failed to award emoji", "error", err, "class", classifyAPIError(err))
 	}
 }
 
@@ -45,7 +75,7 @@ func (e MergeRequestLookupError) Error() string {
 	return fmt.Sprintf("failed to get merge request %d: %s", e.mrId, e.err)
 }
 
-func (h *Webhook) revParseRemote(projectId int, branchName string) (*models.GitRef, error) {
+func (h *Webhook) revParseRemote(ctx context.Context, projectId int, branchName string) (*models.GitRef, error) {
 	if strings.HasPrefix(branchName, "!") {
 		mrIdStr := strings.TrimPrefix(branchName, "!")
 		mrId, err := strconv.Atoi(mrIdStr)
@@ -55,19 +85,59 @@ func (h *Webhook) revParseRemote(projectId int, branchName string) (*models.GitR
 				err:  fmt.Sprintf("invalid merge request ID: %s", err.Error()),
 			}
 		}
-		mr, _, err := h.gl.MergeRequests.GetMergeRequest(projectId, mrId, nil)
+		mr, _, err := h.gl.MergeRequests.GetMergeRequest(projectId, mrId, nil, gitlab.WithContext(ctx))
 		if err != nil {
 			return nil, MergeRequestLookupError{
 				mrId: mrId,
 				err:  fmt.Sprintf("failed to get merge request: %s", err.Error()),
 			}
 		}
+		if err := git.CheckBranchName(mr.SourceBranch); err != nil {
+			return nil, err
+		}
 		return &models.GitRef{Name: mr.SourceBranch, Commit: mr.DiffRefs.HeadSha}, nil
 	} else {
-		branch, _, err := h.gl.Branches.GetBranch(projectId, branchName)
+		if err := git.CheckBranchName(branchName); err != nil {
+			return nil, err
+		}
+		branch, _, err := h.gl.Branches.GetBranch(projectId, branchName, gitlab.WithContext(ctx))
 		if err != nil {
-			return nil, fmt.Errorf("failed to get branch: %w", err)
+			return nil, describeAPIError("branch "+branchName, err)
 		}
 		return &models.GitRef{Name: branchName, Commit: branch.Commit.ID}, nil
 	}
 }
+
+// lookupMember resolves the branch or merge request name of a command to a ref, replying to the command why if it
+// can't. A deleted branch resolves to a ref without commit if allowDeleted is set, so that it can be removed by name.
+func (h *Webhook) lookupMember(ctx context.Context, event *gitlab.IssueCommentEvent, logger *slog.Logger, name string, allowDeleted bool) (*models.GitRef, bool) {
+	ref, err := h.revParseRemote(ctx, event.ProjectID, name)
+	var mrLookupErr MergeRequestLookupError
+	var invalidRefErr *git.InvalidRefError
+	switch {
+	case err == nil:
+		return ref, true
+	case errors.As(err, &mrLookupErr):
+		logger.Error("Failed to get remote ref", "error", err)
+		go h.reply(event, fmt.Sprintf("merge request %s lookup failed ", name))
+	case errors.As(err, &invalidRefErr):
+		logger.Error("Invalid branch name", "error", err)
+		go h.reply(event, invalidRefErr.Error())
+	case allowDeleted && classifyAPIError(err) == apiErrorNotFound:
+		return &models.GitRef{Name: name}, true
+	default:
+		logger.Error("Failed to get remote ref", "error", err)
+		go h.reply(event, err.Error())
+	}
+	return nil, false
+}
+
+// memberFetchRefs returns the refs to fetch for the member name of a command resolved to ref
+func memberFetchRefs(name string, ref *models.GitRef) []string {
+	// merge requests from forks are only available under refs/merge-requests of the project
+	refs := []string{"refs/heads/" + ref.Name}
+	if mrId, ok := strings.CutPrefix(name, "!"); ok {
+		refs = append(refs, fmt.Sprintf("refs/merge-requests/%s/head", mrId))
+	}
+	return refs
+}
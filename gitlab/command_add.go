@@ -1,15 +1,19 @@
 package gitlab
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/jizhilong/branch-bot/core"
+	"github.com/jizhilong/branch-bot/models"
 	"github.com/xanzy/go-gitlab"
 	"log/slog"
+	"strings"
 )
 
 type AddCommand struct {
 	BranchName string
+	// After are the branches this branch builds on
+	After []string
 }
 
 func (c *AddCommand) CommandName() string {
@@ -17,26 +21,49 @@ func (c *AddCommand) CommandName() string {
 }
 
 func (c *AddCommand) String() string {
+	if len(c.After) > 0 {
+		return fmt.Sprintf("%s %s --after %s", c.CommandName(), c.BranchName, strings.Join(c.After, ","))
+	}
 	return fmt.Sprintf("%s %s", c.CommandName(), c.BranchName)
 }
 
-func (c *AddCommand) Process(h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
+func (c *AddCommand) Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
 	logger = logger.With("branch", c.BranchName)
-	ref, err := h.revParseRemote(event.ProjectID, c.BranchName)
-	var mrLookupErr MergeRequestLookupError
-	if err != nil && errors.As(err, &mrLookupErr) {
-		logger.Error("Failed to get remote ref", "error", err)
-		go h.reply(event, fmt.Sprintf("merge request %s lookup failed ", c.BranchName))
+	ref, ok := h.lookupMember(ctx, event, logger, c.BranchName, false)
+	if !ok {
 		return
 	}
-	result, fail := operator.AddAndPush(ref)
+	after := make([]string, 0, len(c.After))
+	for _, name := range c.After {
+		// Dependencies are referred by branch names, resolve merge requests to their source branches
+		if strings.HasPrefix(name, "!") {
+			depRef, err := h.revParseRemote(ctx, event.ProjectID, name)
+			if err != nil {
+				logger.Error("Failed to get remote ref", "error", err)
+				go h.reply(event, fmt.Sprintf("merge request %s lookup failed ", name))
+				return
+			}
+			name = depRef.Name
+		}
+		after = append(after, name)
+	}
+	var result *models.GitMergeResult
+	fail := operator.Fetch(ctx, memberFetchRefs(c.BranchName, ref)...)
+	if fail == nil {
+		result, fail = operator.AddAndPush(ctx, ref, after...)
+	}
 	if fail == nil {
 		logger.Info("Successfully added branch", "result", result)
 	} else {
 		logger.Error("Failed to add branch", "error", fail)
 	}
 	h.awardEmojiAgainstError(event, fail)
-	err = operator.SyncMergeTrainView(&MergeTrainViewGlHelper{gl: h.gl, event: event, err: fail})
+	reportCtx, cancel := reportContext(ctx)
+	defer cancel()
+	helper := h.viewHelper(reportCtx, event, fail)
+	helper.freshBranches = []string{ref.Name}
+	helper.resultURL = h.postResult(reportCtx, event, fail)
+	err := operator.SyncMergeTrainView(reportCtx, helper)
 	if err != nil {
 		logger.Error("Failed to sync merge train view", "error", err)
 		return
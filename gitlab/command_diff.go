@@ -0,0 +1,50 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"github.com/jizhilong/branch-bot/core"
+	"github.com/xanzy/go-gitlab"
+	"log/slog"
+)
+
+// DiffCommand shows what a member changed since it was merged, without changing the merge train
+type DiffCommand struct {
+	BranchName string
+}
+
+func (c *DiffCommand) CommandName() string {
+	return "diff"
+}
+
+func (c *DiffCommand) String() string {
+	return fmt.Sprintf("%s %s", c.CommandName(), c.BranchName)
+}
+
+func (c *DiffCommand) Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
+	logger = logger.With("branch", c.BranchName)
+	ref, ok := h.lookupMember(ctx, event, logger, c.BranchName, false)
+	if !ok {
+		return
+	}
+	fail := operator.Fetch(ctx, memberFetchRefs(c.BranchName, ref)...)
+	var message string
+	if fail == nil {
+		diff, err := operator.MemberDiff(ctx, h.viewHelper(ctx, event, nil), ref.Name, ref.Commit)
+		if err != nil {
+			fail = err
+		} else if diff.UpToDate() {
+			message = diff.AsMarkdown()
+		} else {
+			message = fmt.Sprintf("**%s**\n\n%s", diff.Summary(), diff.AsMarkdown())
+		}
+	}
+	h.awardEmojiAgainstError(event, fail)
+	if fail != nil {
+		logger.Error("Failed to diff branch", "error", fail)
+		reportCtx, cancel := reportContext(ctx)
+		defer cancel()
+		message = fmt.Sprintf("failed to process: %s", h.viewHelper(reportCtx, event, fail).errorToMarkdown(fail))
+	}
+	go h.reply(event, message)
+}
@@ -1,18 +1,24 @@
 package gitlab
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"github.com/jizhilong/branch-bot/core"
+	"github.com/jizhilong/branch-bot/models"
 	"github.com/xanzy/go-gitlab"
 	"log/slog"
 )
 
 type RemoveCommand struct {
 	BranchName string
+	// Cascade removes the members depending on the branch as well
+	Cascade bool
 }
 
 func (c *RemoveCommand) String() string {
+	if c.Cascade {
+		return fmt.Sprintf("%s %s --cascade", c.CommandName(), c.BranchName)
+	}
 	return fmt.Sprintf("%s %s", c.CommandName(), c.BranchName)
 }
 
@@ -20,23 +26,29 @@ func (c *RemoveCommand) CommandName() string {
 	return "remove"
 }
 
-func (c *RemoveCommand) Process(h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
+func (c *RemoveCommand) Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
 	logger = logger.With("branch", c.BranchName)
-	ref, err := h.revParseRemote(event.ProjectID, c.BranchName)
-	var mrLookupErr MergeRequestLookupError
-	if err != nil && errors.As(err, &mrLookupErr) {
-		logger.Error("Failed to get remote ref", "error", err)
-		go h.reply(event, fmt.Sprintf("merge request %s lookup failed ", c.BranchName))
+	ref, ok := h.lookupMember(ctx, event, logger, c.BranchName, true)
+	if !ok {
 		return
 	}
-	result, fail := operator.RemoveAndPush(ref.Name)
+	var result *models.GitMergeResult
+	fail := operator.Fetch(ctx)
+	if fail == nil {
+		result, fail = operator.RemoveAndPush(ctx, ref.Name, c.Cascade)
+	}
 	if fail != nil {
 		logger.Error("Failed to remove branch", "error", fail)
 	} else {
 		logger.Info("Successfully removed branch", "result", result)
 	}
 	h.awardEmojiAgainstError(event, fail)
-	err = operator.SyncMergeTrainView(&MergeTrainViewGlHelper{gl: h.gl, event: event, err: fail})
+	reportCtx, cancel := reportContext(ctx)
+	defer cancel()
+	helper := h.viewHelper(reportCtx, event, fail)
+	helper.freshBranches = []string{ref.Name}
+	helper.resultURL = h.postResult(reportCtx, event, fail)
+	err := operator.SyncMergeTrainView(reportCtx, helper)
 	if err != nil {
 		logger.Error("Failed to sync merge train view", "error", err)
 		go h.reply(event, "failed to sync merge train view")
@@ -0,0 +1,50 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"github.com/jizhilong/branch-bot/core"
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/xanzy/go-gitlab"
+	"log/slog"
+)
+
+// ResolveCommand registers a commit resolving the conflicts between two branches
+type ResolveCommand struct {
+	Branches [2]string
+	Commit   string
+}
+
+func (c *ResolveCommand) CommandName() string {
+	return "resolve"
+}
+
+func (c *ResolveCommand) String() string {
+	return fmt.Sprintf("%s %s %s %s", c.CommandName(), c.Branches[0], c.Branches[1], c.Commit)
+}
+
+func (c *ResolveCommand) Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
+	logger = logger.With("branches", c.Branches, "commit", c.Commit)
+	var result *models.GitMergeResult
+	// the resolution may be pushed to any branch, fetch it by commit id
+	fail := operator.Fetch(ctx, "refs/heads/"+c.Branches[0], "refs/heads/"+c.Branches[1], c.Commit)
+	if fail == nil {
+		result, fail = operator.ResolveAndPush(ctx, c.Branches[0], c.Branches[1], c.Commit)
+	}
+	if fail == nil {
+		logger.Info("Successfully registered conflict resolution", "result", result)
+	} else {
+		logger.Error("Failed to register conflict resolution", "error", fail)
+	}
+	h.awardEmojiAgainstError(event, fail)
+	reportCtx, cancel := reportContext(ctx)
+	defer cancel()
+	helper := h.viewHelper(reportCtx, event, fail)
+	helper.freshBranches = c.Branches[:]
+	helper.resultURL = h.postResult(reportCtx, event, fail)
+	err := operator.SyncMergeTrainView(reportCtx, helper)
+	if err != nil {
+		logger.Error("Failed to sync merge train view", "error", err)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package gitlab
 
 import (
+	"context"
 	"github.com/jizhilong/branch-bot/core"
 	"github.com/xanzy/go-gitlab"
 	"log/slog"
@@ -16,8 +17,10 @@ func (c StatusCommand) String() string {
 	return "status"
 }
 
-func (c StatusCommand) Process(h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
-	err := operator.SyncMergeTrainView(&MergeTrainViewGlHelper{gl: h.gl, event: event})
+func (c StatusCommand) Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator) {
+	helper := h.viewHelper(ctx, event, nil)
+	helper.resultURL = h.postResult(ctx, event, nil)
+	err := operator.SyncMergeTrainView(ctx, helper)
 	if err != nil {
 		logger.Error("Failed to sync merge train view", "error", err)
 		go h.reply(event, "failed to sync merge train view")
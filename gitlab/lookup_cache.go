@@ -0,0 +1,116 @@
+package gitlab
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jizhilong/branch-bot/models"
+)
+
+// Lookups of GitLab are cached for a while, sparing the API from building the views of large merge trains
+// again and again. Branches move, so their lookups expire quickly, while the web URL of a project rarely changes.
+// Finished pipelines of a commit only change when retried, whose pipeline events drop them from the cache.
+const (
+	branchLookupTTL   = 30 * time.Second
+	pipelineLookupTTL = 10 * time.Minute
+	projectLookupTTL  = time.Hour
+)
+
+// branchKey identifies a branch of a project
+type branchKey struct {
+	projectID int
+	branch    string
+}
+
+// commitKey identifies a commit of a project
+type commitKey struct {
+	projectID int
+	sha       string
+}
+
+// lookupCache caches the results of GitLab lookups shared by the views of all issues,
+// failed lookups are not cached
+type lookupCache struct {
+	projectURLs   ttlCache[int, string]
+	latestCommits ttlCache[branchKey, *models.CommitView]
+	mergeRequests ttlCache[branchKey, *models.MergeRequestView]
+	// pipelines only holds finished pipelines, running ones change any moment
+	pipelines ttlCache[commitKey, *models.PipelineView]
+}
+
+func newLookupCache() *lookupCache {
+	return &lookupCache{
+		projectURLs:   ttlCache[int, string]{ttl: projectLookupTTL},
+		latestCommits: ttlCache[branchKey, *models.CommitView]{ttl: branchLookupTTL},
+		mergeRequests: ttlCache[branchKey, *models.MergeRequestView]{ttl: branchLookupTTL},
+		pipelines:     ttlCache[commitKey, *models.PipelineView]{ttl: pipelineLookupTTL},
+	}
+}
+
+// ttlCache is a map whose entries expire ttl after they are set, safe for concurrent use
+type ttlCache[K comparable, V any] struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[K]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// get returns the cached value of key, if there is one and it hasn't expired
+func (c *ttlCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !time.Now().Before(entry.expires) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set caches value for key until ttl from now
+func (c *ttlCache[K, V]) set(key K, value V) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[K]ttlEntry[V])
+	}
+	// expired entries are dropped as new ones come in, keeping the cache as large as its recent lookups
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// delete drops the cached value of key
+func (c *ttlCache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// getOrLoad returns the cached value of key, calling load and caching its result unless it fails
+// if there is none or it has expired
+func (c *ttlCache[K, V]) getOrLoad(key K, load func() (V, error)) (V, error) {
+	if value, ok := c.get(key); ok {
+		return value, nil
+	}
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.set(key, value)
+	return value, nil
+}
+
+// reload calls load and caches its result unless it fails, regardless of the cached value
+func (c *ttlCache[K, V]) reload(key K, load func() (V, error)) (V, error) {
+	c.delete(key)
+	return c.getOrLoad(key, load)
+}
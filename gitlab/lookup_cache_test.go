@@ -0,0 +1,111 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestTTLCache(t *testing.T) {
+	cache := ttlCache[string, int]{ttl: time.Hour}
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return loads, nil
+	}
+
+	value, err := cache.getOrLoad("a", load)
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, _ = cache.getOrLoad("a", load)
+	assert.Equal(t, 1, value, "cached value is reused")
+	value, _ = cache.reload("a", load)
+	assert.Equal(t, 2, value, "reload looks up again")
+	value, _ = cache.getOrLoad("a", load)
+	assert.Equal(t, 2, value, "reloaded value is cached")
+
+	// failures are not cached
+	_, err = cache.getOrLoad("b", func() (int, error) { return 0, errors.New("rate limited") })
+	assert.Error(t, err)
+	value, err = cache.getOrLoad("b", load)
+	require.NoError(t, err)
+	assert.Equal(t, 3, value)
+
+	// expired values are looked up again and dropped
+	expiring := ttlCache[string, int]{ttl: time.Millisecond}
+	_, _ = expiring.getOrLoad("a", load)
+	_, _ = expiring.getOrLoad("b", load)
+	time.Sleep(2 * time.Millisecond)
+	value, _ = expiring.getOrLoad("a", load)
+	assert.Equal(t, 6, value)
+	assert.Len(t, expiring.entries, 1)
+}
+
+func TestCachedLookups(t *testing.T) {
+	var branchLookups, pipelineLookups atomic.Int32
+	var pipelineStatus atomic.Value
+	pipelineStatus.Store("running")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v4/projects/1/repository/branches/feature", "/api/v4/projects/1/repository/branches/fix":
+			n := branchLookups.Add(1)
+			_, _ = fmt.Fprintf(w, `{"commit": {"id": "sha%d"}}`, n)
+		case "/api/v4/projects/1/pipelines":
+			pipelineLookups.Add(1)
+			_, _ = fmt.Fprintf(w, `[{"id": 1, "status": %q}]`, pipelineStatus.Load())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	gl, err := gitlab.NewClient("token", append(apiClientOptions(), gitlab.WithBaseURL(server.URL))...)
+	require.NoError(t, err)
+	h := &Webhook{gl: gl, lookups: newLookupCache()}
+	event := &gitlab.IssueCommentEvent{ProjectID: 1}
+	view := MergeTrainViewGlHelper{ctx: context.Background(), gl: gl, event: event, cache: h.lookups}
+
+	// views reuse cached branches, commands look up the branches they touched afresh
+	first, err := view.GetBranchLatestCommit(1, "feature")
+	require.NoError(t, err)
+	_, _ = view.GetBranchLatestCommit(1, "fix")
+	command := view
+	command.freshBranches = []string{"feature"}
+	fresh, _ := command.GetBranchLatestCommit(1, "feature")
+	assert.NotEqual(t, first.SHA, fresh.SHA)
+	_, _ = command.GetBranchLatestCommit(1, "fix")
+	assert.Equal(t, int32(3), branchLookups.Load())
+	cached, _ := view.GetBranchLatestCommit(1, "feature")
+	assert.Equal(t, fresh.SHA, cached.SHA)
+	assert.Equal(t, int32(3), branchLookups.Load())
+
+	// only finished pipelines are cached
+	pipeline, err := view.GetPipeline(1, "sha1")
+	require.NoError(t, err)
+	assert.Equal(t, "running", pipeline.Status)
+	pipelineStatus.Store("success")
+	pipeline, _ = view.GetPipeline(1, "sha1")
+	assert.Equal(t, "success", pipeline.Status)
+	_, _ = view.GetPipeline(1, "sha1")
+	assert.Equal(t, int32(2), pipelineLookups.Load())
+
+	// until a pipeline of the commit is retried
+	retried := &gitlab.PipelineEvent{}
+	retried.Project.ID = 1
+	retried.ObjectAttributes.SHA = "sha1"
+	retried.ObjectAttributes.Status = "running"
+	retried.ObjectAttributes.Ref = "feature"
+	h.handlePipeline(retried)
+	pipelineStatus.Store("running")
+	pipeline, _ = view.GetPipeline(1, "sha1")
+	assert.Equal(t, "running", pipeline.Status)
+	assert.Equal(t, int32(3), pipelineLookups.Load())
+}
@@ -0,0 +1,67 @@
+package gitlab
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// finishedPipelineStatuses are the statuses of pipelines that won't change any more
+var finishedPipelineStatuses = []string{"success", "failed", "canceled", "skipped"}
+
+// handlePipeline refreshes the issue of a merge train when a pipeline of its bb branch finishes
+func (h *Webhook) handlePipeline(e *gitlab.PipelineEvent) {
+	// a retried pipeline replaces the finished one cached for the commit
+	h.lookups.pipelines.delete(commitKey{e.Project.ID, e.ObjectAttributes.SHA})
+	if e.ObjectAttributes.Tag || !slices.Contains(finishedPipelineStatuses, e.ObjectAttributes.Status) {
+		return
+	}
+	issueIID, ok := h.pipelineIssueIID(e.ObjectAttributes.Ref)
+	if !ok {
+		return
+	}
+	logger := slog.With(
+		"gitlab", h.gl.BaseURL().String(),
+		"project_id", e.Project.ID,
+		"issue_id", issueIID,
+		"pipeline_id", e.ObjectAttributes.ID,
+	)
+	ctx, cancel := context.WithTimeout(h.ctx, h.cfg.CommandTimeout)
+	defer cancel()
+	operator, release, err := h.getOperator(ctx, e.Project.ID, issueIID, e.Project.PathWithNamespace, e.Project.GitHTTPURL, e.Project.GitSSHURL, e.Project.DefaultBranch)
+	if err != nil {
+		logger.Error("Failed to load merge train", "error", err)
+		return
+	}
+	defer release()
+
+	// the view links GitLab pages of the project the way a comment on the issue does
+	event := &gitlab.IssueCommentEvent{ProjectID: e.Project.ID}
+	event.Project.WebURL = e.Project.WebURL
+	event.Project.PathWithNamespace = e.Project.PathWithNamespace
+	event.Issue.IID = issueIID
+	logger.Info("Refreshing merge train view", "status", e.ObjectAttributes.Status)
+	helper := h.viewHelper(ctx, event, nil)
+	helper.keepCommandLog = true
+	err = operator.SyncMergeTrainView(ctx, helper)
+	if err != nil {
+		logger.Error("Failed to sync merge train view", "error", err)
+	}
+}
+
+// pipelineIssueIID returns the issue of the merge train whose bb branch is ref
+func (h *Webhook) pipelineIssueIID(ref string) (int, bool) {
+	suffix, ok := strings.CutPrefix(ref, h.branchNamePrefix)
+	if !ok {
+		return 0, false
+	}
+	issueIID, err := strconv.Atoi(suffix)
+	if err != nil || issueIID <= 0 {
+		return 0, false
+	}
+	return issueIID, true
+}
@@ -0,0 +1,84 @@
+package gitlab
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/jizhilong/branch-bot/config"
+	"github.com/jizhilong/branch-bot/models"
+)
+
+// defaultIssueTemplate renders the status block of the issue description unless overridden
+//
+//go:embed templates/issue.md.tmpl
+var defaultIssueTemplate string
+
+// defaultTemplate is defaultIssueTemplate parsed
+var defaultTemplate = template.Must(parseIssueTemplate("default", defaultIssueTemplate))
+
+// templateFuncs are the functions available to issue templates besides the builtin ones
+var templateFuncs = template.FuncMap{
+	"short": func(sha string) string {
+		if len(sha) > 8 {
+			return sha[:8]
+		}
+		return sha
+	},
+	"join":    strings.Join,
+	"replace": strings.ReplaceAll,
+}
+
+// IssueTemplateData is what issue templates are executed with
+type IssueTemplateData struct {
+	// View is the current merge train
+	View *models.MergeTrainView
+	// Command is the command being processed as shown in the command log, a short line without backticks,
+	// empty if the view is refreshed without a command
+	Command string
+	// User is the username of the author of the command
+	User string
+	// Error is the error of the command, nil if it succeeded
+	Error error
+	// ErrorMarkdown is the error of the command rendered as markdown, with conflict details
+	ErrorMarkdown string
+}
+
+// parseIssueTemplate parses an issue template
+func parseIssueTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// loadIssueTemplates parses the issue templates configured for any project by path,
+// so that broken templates are found on startup. The default template is keyed by the empty path.
+func loadIssueTemplates(cfg *config.Config) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{"": defaultTemplate}
+	paths := []string{cfg.Default.IssueTemplate}
+	for _, project := range cfg.Projects {
+		paths = append(paths, project.IssueTemplate)
+	}
+	for _, path := range paths {
+		if _, ok := templates[path]; ok {
+			continue
+		}
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read issue template: %w", err)
+		}
+		if templates[path], err = parseIssueTemplate(path, string(text)); err != nil {
+			return nil, fmt.Errorf("failed to parse issue template: %w", err)
+		}
+	}
+	return templates, nil
+}
+
+// renderIssue executes an issue template into the status block
+func renderIssue(tmpl *template.Template, data *IssueTemplateData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render issue template %s: %w", tmpl.Name(), err)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
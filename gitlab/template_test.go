@@ -0,0 +1,89 @@
+package gitlab
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jizhilong/branch-bot/config"
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderIssue(t *testing.T) {
+	view := &models.MergeTrainView{
+		Branch: "bb-branches/1",
+		URL:    "https://gitlab.example.com/group/project/-/tree/bb-branches/1",
+		Commit: &models.CommitView{SHA: "0123456789abcdef", URL: "https://gitlab.example.com/group/project/-/commit/0123456789abcdef"},
+		Members: []models.MemberView{
+			{
+				Branch:       "feature-a",
+				BranchURL:    "https://gitlab.example.com/group/project/-/tree/feature-a",
+				MergedCommit: &models.CommitView{SHA: "aaaaaaaaaaaaaaaa", URL: "https://gitlab.example.com/group/project/-/commit/aaaaaaaaaaaaaaaa"},
+			},
+		},
+		AutoResolved: []models.FileAutoResolution{{Path: "CHANGELOG.md", Strategy: "union"}},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		got, err := renderIssue(defaultTemplate, &IssueTemplateData{View: view})
+		require.NoError(t, err)
+		want := "## Current Status\n\n" + view.RenderMermaid() + "\n" + view.RenderTable() +
+			"\n\n### Automatically Resolved Conflicts\n\n" + view.RenderAutoResolved()
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("default with command", func(t *testing.T) {
+		got, err := renderIssue(defaultTemplate, &IssueTemplateData{View: view, Command: "!bb add feature-a", User: "dev"})
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(got, "\n\n### Last Command\n\n`!bb add feature-a` by @dev: all goes well"), got)
+
+		fail := errors.New("conflict")
+		got, err = renderIssue(defaultTemplate, &IssueTemplateData{View: view, Command: "!bb add feature-b", User: "dev",
+			Error: fail, ErrorMarkdown: "**conflict**"})
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(got, "\n\n### Last Command\n\n`!bb add feature-b` by @dev: failed to process: **conflict**"), got)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		tmpl, err := parseIssueTemplate("custom", "{{ .View.Branch }} at {{ short .View.Commit.SHA }}\n"+
+			"{{ range .View.Members }}- {{ .Branch }}\n{{ end }}"+
+			"{{ with .Error }}@{{ $.User }}: {{ . }}{{ end }}\n")
+		require.NoError(t, err)
+		got, err := renderIssue(tmpl, &IssueTemplateData{View: view, User: "dev", Error: errors.New("conflict")})
+		require.NoError(t, err)
+		assert.Equal(t, "bb-branches/1 at 01234567\n- feature-a\n@dev: conflict", got)
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		tmpl, err := parseIssueTemplate("broken", "{{ .View.Nope }}")
+		require.NoError(t, err)
+		_, err = renderIssue(tmpl, &IssueTemplateData{View: view})
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadIssueTemplates(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.md.tmpl")
+	require.NoError(t, os.WriteFile(custom, []byte("{{ .View.RenderTable }}"), 0644))
+	cfg := &config.Config{Projects: map[string]config.ProjectConfig{"group/project": {IssueTemplate: custom}}}
+
+	templates, err := loadIssueTemplates(cfg)
+	require.NoError(t, err)
+	assert.Same(t, defaultTemplate, templates[""])
+	assert.NotNil(t, templates[custom])
+
+	broken := filepath.Join(dir, "broken.md.tmpl")
+	require.NoError(t, os.WriteFile(broken, []byte("{{ .View.RenderTable "), 0644))
+	cfg.Default.IssueTemplate = broken
+	_, err = loadIssueTemplates(cfg)
+	assert.ErrorContains(t, err, "failed to parse issue template")
+
+	cfg.Default.IssueTemplate = filepath.Join(dir, "missing.md.tmpl")
+	_, err = loadIssueTemplates(cfg)
+	assert.ErrorContains(t, err, "failed to read issue template")
+}
@@ -0,0 +1,62 @@
+package gitlab
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/xanzy/go-gitlab"
+)
+
+// uploadOversized uploads the conflict details and command outputs of err too large to show in markdown
+// to the project, so that its markdown links to them. Uploads that fail leave them truncated.
+func (m MergeTrainViewGlHelper) uploadOversized(err error) {
+	var mergeFail *models.GitMergeFailResult
+	if errors.As(err, &mergeFail) {
+		for i := range mergeFail.FailedFiles {
+			file := &mergeFail.FailedFiles[i]
+			if len(file.ConflictDetail) >= models.MaxConflictDetailSize && file.DetailURL == "" {
+				file.DetailURL = m.upload(uploadName(file.Path)+".diff", file.ConflictDetail)
+			}
+		}
+		m.uploadOutputs(&mergeFail.CommandExecFail)
+		return
+	}
+	var validationFail *models.ValidationFail
+	if errors.As(err, &validationFail) {
+		m.uploadOutputs(&validationFail.CommandExecFail)
+		return
+	}
+	var execFail *models.CommandExecFail
+	if errors.As(err, &execFail) {
+		m.uploadOutputs(execFail)
+	}
+}
+
+// uploadOutputs uploads the outputs of a failed command that are too long to show
+func (m MergeTrainViewGlHelper) uploadOutputs(fail *models.CommandExecFail) {
+	if len(fail.Stdout) > models.MaxOutputSize && fail.StdoutURL == "" {
+		fail.StdoutURL = m.upload("stdout.txt", fail.Stdout)
+	}
+	if len(fail.Stderr) > models.MaxOutputSize && fail.StderrURL == "" {
+		fail.StderrURL = m.upload("stderr.txt", fail.Stderr)
+	}
+}
+
+// upload uploads content as a file of the project, returning its URL or an empty string if it failed
+func (m MergeTrainViewGlHelper) upload(name, content string) string {
+	file, _, err := m.gl.Projects.UploadFile(m.event.ProjectID, strings.NewReader(content), name, gitlab.WithContext(m.ctx))
+	if err != nil {
+		slog.Error("Failed to upload file", "name", name, "error", err, "class", classifyAPIError(err))
+		return ""
+	}
+	// the URL of uploads is relative to the project
+	return fmt.Sprintf("%s%s", m.event.Project.WebURL, file.URL)
+}
+
+// uploadName turns a path into a file name of an upload
+func uploadName(path string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(path)
+}
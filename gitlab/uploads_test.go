@@ -0,0 +1,76 @@
+package gitlab
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jizhilong/branch-bot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestUploadOversized(t *testing.T) {
+	var uploads atomic.Int32
+	var forbidden atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/projects/1/uploads" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if forbidden.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, header, err := r.FormFile("file")
+		if !assert.NoError(t, err) {
+			return
+		}
+		uploads.Add(1)
+		_, _ = w.Write([]byte(`{"url": "/uploads/secret/` + header.Filename + `"}`))
+	}))
+	defer server.Close()
+	gl, err := gitlab.NewClient("token", append(apiClientOptions(), gitlab.WithBaseURL(server.URL))...)
+	require.NoError(t, err)
+	event := &gitlab.IssueCommentEvent{ProjectID: 1}
+	event.Project.WebURL = "https://gitlab.example.com/group/project"
+	helper := MergeTrainViewGlHelper{ctx: context.Background(), gl: gl, event: event}
+
+	newFail := func() *models.GitMergeFailResult {
+		return &models.GitMergeFailResult{
+			CommandExecFail: models.CommandExecFail{
+				Cmdline: "git merge",
+				Status:  "exit status 1",
+				Stdout:  strings.Repeat("Auto-merging file.txt\n", models.MaxOutputSize/10) + "CONFLICT (content): Merge conflict in src/main.go",
+				Stderr:  "fatal: merge failed",
+			},
+			FailedFiles: []models.FileMergeConflict{
+				{Path: "src/main.go", ConflictType: "content", ConflictDetail: strings.Repeat("+line\n", models.MaxConflictDetailSize)},
+				{Path: "README.md", ConflictType: "content", ConflictDetail: "-old\n+new"},
+			},
+		}
+	}
+
+	fail := newFail()
+	markdown := helper.errorToMarkdown(fail)
+	assert.Contains(t, markdown, "diff too large, [download it](https://gitlab.example.com/group/project/uploads/secret/src_main.go.diff)")
+	assert.Contains(t, markdown, "(truncated, [full stdout](https://gitlab.example.com/group/project/uploads/secret/stdout.txt))")
+	assert.Contains(t, markdown, "CONFLICT (content): Merge conflict in src/main.go", "the end of outputs is kept")
+	assert.Contains(t, markdown, "+new")
+	assert.Less(t, len(markdown), models.MaxOutputSize+5000)
+	assert.Equal(t, int32(2), uploads.Load())
+	// rendering again reuses the uploads
+	assert.Equal(t, markdown, helper.errorToMarkdown(fail))
+	assert.Equal(t, int32(2), uploads.Load())
+
+	// failed uploads leave the details out
+	forbidden.Store(true)
+	markdown = helper.errorToMarkdown(newFail())
+	assert.Contains(t, markdown, "diff too large, not shown")
+	assert.Contains(t, markdown, "**stdout**: (truncated)")
+	assert.Less(t, len(markdown), models.MaxOutputSize+5000)
+}
@@ -1,81 +1,181 @@
 package gitlab
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jizhilong/branch-bot/models"
 	"github.com/xanzy/go-gitlab"
 	"log/slog"
+	"slices"
+	"strings"
+	"text/template"
 )
 
 type MergeTrainViewGlHelper struct {
+	// ctx is the context of GitLab API calls, retries of rate limited calls give up with it
+	ctx   context.Context
 	gl    *gitlab.Client
 	event *gitlab.IssueCommentEvent
 	err   error
+	// keepCommandLog keeps the command log of the issue as is, for views refreshed without a command
+	keepCommandLog bool
+	// resultURL links the command log entry of the command to the reply with its result,
+	// the command itself if empty
+	resultURL string
+	// cache holds lookups shared with other views, nothing is cached if nil
+	cache *lookupCache
+	// freshBranches are the branches just pushed by the command, looked up afresh instead of reusing cached lookups
+	freshBranches []string
+	// template renders the status block, the default template if nil
+	template *template.Template
 }
 
 func (m MergeTrainViewGlHelper) BranchURL(projectID int, branchName string) string {
-	if projectID == m.event.ProjectID {
-		return fmt.Sprintf("%s/-/tree/%s", m.event.Project.WebURL, branchName)
-	}
-	project, _, err := m.gl.Projects.GetProject(projectID, nil)
+	webURL, err := m.projectWebURL(projectID)
 	if err != nil {
-		slog.Error("failed to get project", "projectID", projectID, "error", err)
+		slog.Error("failed to get project", "projectID", projectID, "error", err, "class", classifyAPIError(err))
 		return ""
 	}
-	return fmt.Sprintf("%s/-/tree/%s", project.WebURL, branchName)
+	return fmt.Sprintf("%s/-/tree/%s", webURL, branchName)
 }
 
 func (m MergeTrainViewGlHelper) CommitURL(projectID int, commitSHA string) string {
-	if projectID == m.event.ProjectID {
-		return fmt.Sprintf("%s/-/commit/%s", m.event.Project.WebURL, commitSHA)
+	webURL, err := m.projectWebURL(projectID)
+	if err != nil {
+		slog.Error("failed to get project", "projectID", projectID, "error", err, "class", classifyAPIError(err))
+		return ""
 	}
-	project, _, err := m.gl.Projects.GetProject(projectID, nil)
+	return fmt.Sprintf("%s/-/commit/%s", webURL, commitSHA)
+}
+
+func (m MergeTrainViewGlHelper) CompareURL(projectID int, from, to string) string {
+	webURL, err := m.projectWebURL(projectID)
 	if err != nil {
-		slog.Error("failed to get project", "projectID", projectID, "error", err)
+		slog.Error("failed to get project", "projectID", projectID, "error", err, "class", classifyAPIError(err))
 		return ""
 	}
-	return fmt.Sprintf("%s/-/commit/%s", project.WebURL, commitSHA)
+	return fmt.Sprintf("%s/-/compare/%s...%s", webURL, from, to)
+}
+
+// projectWebURL returns the web URL of a project, looking up projects other than the one of the issue
+func (m MergeTrainViewGlHelper) projectWebURL(projectID int) (string, error) {
+	if projectID == m.event.ProjectID {
+		return m.event.Project.WebURL, nil
+	}
+	load := func() (string, error) {
+		project, _, err := m.gl.Projects.GetProject(projectID, nil, gitlab.WithContext(m.ctx))
+		if err != nil {
+			return "", err
+		}
+		return project.WebURL, nil
+	}
+	if m.cache == nil {
+		return load()
+	}
+	return m.cache.projectURLs.getOrLoad(projectID, load)
 }
 
 func (m MergeTrainViewGlHelper) GetBranchLatestCommit(projectID int, branchName string) (*models.CommitView, error) {
-	branch, _, err := m.gl.Branches.GetBranch(projectID, branchName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get branch %s: %w", branchName, err)
+	load := func() (*models.CommitView, error) {
+		branch, _, err := m.gl.Branches.GetBranch(projectID, branchName, gitlab.WithContext(m.ctx))
+		if err != nil {
+			return nil, describeAPIError("branch "+branchName, err)
+		}
+		return &models.CommitView{
+			SHA: branch.Commit.ID,
+			URL: branch.Commit.WebURL,
+		}, nil
 	}
-	return &models.CommitView{
-		SHA: branch.Commit.ID,
-		URL: branch.Commit.WebURL,
-	}, nil
+	if m.cache == nil {
+		return load()
+	}
+	return cachedLookup(&m.cache.latestCommits, branchKey{projectID, branchName}, load, !slices.Contains(m.freshBranches, branchName))
 }
 
 func (m MergeTrainViewGlHelper) GetMergeRequestInfo(projectID int, branchName string) (*models.MergeRequestView, error) {
-	mrList, _, err := m.gl.MergeRequests.ListProjectMergeRequests(projectID, &gitlab.ListProjectMergeRequestsOptions{
-		SourceBranch: &branchName,
+	load := func() (*models.MergeRequestView, error) {
+		mrList, _, err := m.gl.MergeRequests.ListProjectMergeRequests(projectID, &gitlab.ListProjectMergeRequestsOptions{
+			SourceBranch: &branchName,
+			ListOptions: gitlab.ListOptions{
+				Page:    1,
+				PerPage: 1,
+			},
+		}, gitlab.WithContext(m.ctx))
+		if err != nil {
+			return nil, describeAPIError("merge request of "+branchName, err)
+		}
+		if len(mrList) == 0 {
+			return nil, nil
+		} else {
+			mr := mrList[0]
+			return &models.MergeRequestView{
+				IID:    mr.IID,
+				URL:    mr.WebURL,
+				Author: mr.Author.Username,
+				Title:  mr.Title,
+			}, nil
+		}
+	}
+	if m.cache == nil {
+		return load()
+	}
+	return cachedLookup(&m.cache.mergeRequests, branchKey{projectID, branchName}, load, !slices.Contains(m.freshBranches, branchName))
+}
+
+// cachedLookup looks a branch up through cache, reusing the cached result only if cached is set. Commands look
+// the branches they touched up afresh, as they have likely just been pushed, caching the results for later views.
+func cachedLookup[V any](cache *ttlCache[branchKey, V], key branchKey, load func() (V, error), cached bool) (V, error) {
+	if cached {
+		return cache.getOrLoad(key, load)
+	}
+	return cache.reload(key, load)
+}
+
+func (m MergeTrainViewGlHelper) GetPipeline(projectID int, commitSHA string) (*models.PipelineView, error) {
+	if m.cache == nil {
+		return m.loadPipeline(projectID, commitSHA)
+	}
+	key := commitKey{projectID, commitSHA}
+	if pipeline, ok := m.cache.pipelines.get(key); ok {
+		return pipeline, nil
+	}
+	pipeline, err := m.loadPipeline(projectID, commitSHA)
+	if err == nil && pipeline != nil && slices.Contains(finishedPipelineStatuses, pipeline.Status) {
+		m.cache.pipelines.set(key, pipeline)
+	}
+	return pipeline, err
+}
+
+// loadPipeline looks up the latest pipeline of a commit, nil if there is none
+func (m MergeTrainViewGlHelper) loadPipeline(projectID int, commitSHA string) (*models.PipelineView, error) {
+	pipelines, _, err := m.gl.Pipelines.ListProjectPipelines(projectID, &gitlab.ListProjectPipelinesOptions{
+		SHA:     &commitSHA,
+		OrderBy: gitlab.Ptr("id"),
+		Sort:    gitlab.Ptr("desc"),
 		ListOptions: gitlab.ListOptions{
 			Page:    1,
 			PerPage: 1,
 		},
-	})
+	}, gitlab.WithContext(m.ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get merge request: %w", err)
+		return nil, describeAPIError("pipeline of "+commitSHA, err)
 	}
-	if len(mrList) == 0 {
+	if len(pipelines) == 0 {
 		return nil, nil
-	} else {
-		mr := mrList[0]
-		return &models.MergeRequestView{
-			IID:    mr.IID,
-			URL:    mr.WebURL,
-			Author: mr.Author.Username,
-			Title:  mr.Title,
-		}, nil
 	}
+	return &models.PipelineView{Status: pipelines[0].Status, URL: pipelines[0].WebURL}, nil
 }
 
-func errorToMarkdown(err error) string {
+func (m MergeTrainViewGlHelper) errorToMarkdown(err error) string {
 	if err == nil {
 		return ""
 	}
+	m.uploadOversized(err)
+	var mergeFail *models.GitMergeFailResult
+	if errors.As(err, &mergeFail) && mergeFail.ResolveBranch != "" {
+		return mergeFail.AsMarkdown() + m.resolveInstructions(mergeFail)
+	}
 	if mdError, ok := err.(models.MarkdownAble); ok {
 		return mdError.AsMarkdown()
 	}
@@ -87,24 +187,185 @@ func errorToMarkdown(err error) string {
 	}
 }
 
+// resolveInstructions tells developers how to resolve the conflicts with the prepared resolve branch
+func (m MergeTrainViewGlHelper) resolveInstructions(mergeFail *models.GitMergeFailResult) string {
+	branch := mergeFail.ResolveMember
+	resolveCommands := []string{}
+	for _, other := range mergeFail.ConflictBranches {
+		if other != branch {
+			resolveCommands = append(resolveCommands, fmt.Sprintf("!bb resolve %s %s <commit>", other, branch))
+		}
+	}
+	if len(resolveCommands) == 0 {
+		resolveCommands = append(resolveCommands, fmt.Sprintf("!bb resolve <conflicting branch> %s <commit>", branch))
+	}
+	return fmt.Sprintf("\n\n**resolve conflicts locally**: the other members are merged in [%s](%s), "+
+		"merge `%s` into it and resolve the conflicts:\n"+
+		"```\ngit fetch origin\ngit checkout -b resolve-%s origin/%s\ngit merge origin/%s\n"+
+		"# resolve the conflicts, commit and push the result to any branch\n```\n"+
+		"then register the resolution with:\n```\n%s\n```\n",
+		mergeFail.ResolveBranch, m.BranchURL(m.event.ProjectID, mergeFail.ResolveBranch), branch,
+		branch, mergeFail.ResolveBranch, branch, strings.Join(resolveCommands, "\n"))
+}
+
+// commandLogHeading starts the command log section
+const commandLogHeading = "## Command Log"
+
+// The command log keeps the latest commandLogSize commands, commandLogPageSize per page
+const (
+	commandLogSize     = 50
+	commandLogPageSize = 10
+)
+
+// The status block owned by branch-bot in the issue description is delimited by these markers,
+// everything outside them is written by people and left untouched
+const (
+	blockStart = "<!-- branch-bot:start -->"
+	blockEnd   = "<!-- branch-bot:end -->"
+)
+
+// The command log is kept at the end of the status block between these markers, after the output
+// of the issue template, so that templates can neither drop nor pollute it
+const (
+	logStart = "<!-- branch-bot:log -->"
+	logEnd   = "<!-- branch-bot:log-end -->"
+)
+
+// legacyStatusHeading starts descriptions written entirely by versions of branch-bot without markers
+const legacyStatusHeading = "## Current Status"
+
 func (m MergeTrainViewGlHelper) Save(view *models.MergeTrainView) error {
-	lastCommandResult := "and all goes well"
-	if m.err != nil {
-		lastCommandResult = fmt.Sprintf("but failed to process: %s", errorToMarkdown(m.err))
-	}
-	lastCommand := fmt.Sprintf("## Last Command\n> %s\n\nfrom @%s at `%s` %s",
-		m.event.ObjectAttributes.Note, m.event.User.Username, m.event.ObjectAttributes.CreatedAt,
-		lastCommandResult)
-	status := fmt.Sprintf("## Current Status\n\n%s\n%s",
-		view.RenderMermaid(),
-		view.RenderTable())
-	description := fmt.Sprintf("%s\n\n%s", status, lastCommand)
-	_, _, err := m.gl.Issues.UpdateIssue(m.event.ProjectID, m.event.Issue.IID, &gitlab.UpdateIssueOptions{
+	issue, _, err := m.gl.Issues.GetIssue(m.event.ProjectID, m.event.Issue.IID, gitlab.WithContext(m.ctx))
+	if err != nil {
+		return fmt.Errorf("failed to get issue: %w", err)
+	}
+	oldBlock, _, _ := statusBlock(issue.Description)
+	entries := commandLogEntries(oldBlock)
+	if !m.keepCommandLog {
+		entries = append([]string{m.commandLogEntry()}, entries...)
+	}
+	data := &IssueTemplateData{View: view, Error: m.err, ErrorMarkdown: m.errorToMarkdown(m.err)}
+	if !m.keepCommandLog {
+		data.Command = logCommand(m.event.ObjectAttributes.Note)
+		data.User = m.event.User.Username
+	}
+	var log string
+	if len(entries) > 0 {
+		log = renderCommandLog(entries)
+	}
+	tmpl := m.template
+	if tmpl == nil {
+		tmpl = defaultTemplate
+	}
+	block, err := renderIssue(tmpl, data)
+	if err != nil {
+		return err
+	}
+	description := replaceStatusBlock(issue.Description, block, log)
+	_, _, err = m.gl.Issues.UpdateIssue(m.event.ProjectID, m.event.Issue.IID, &gitlab.UpdateIssueOptions{
 		Description: &description,
-	})
+	}, gitlab.WithContext(m.ctx))
 	if err != nil {
 		return fmt.Errorf("failed to update issue: %w", err)
 	} else {
 		return nil
 	}
 }
+
+// commandLogEntry returns the entry of the command in the command log
+func (m MergeTrainViewGlHelper) commandLogEntry() string {
+	emoji := ":white_check_mark:"
+	if m.err != nil {
+		emoji = ":x:"
+	}
+	link := m.resultURL
+	if link == "" {
+		link = m.event.ObjectAttributes.URL
+	}
+	return fmt.Sprintf("- %s `%s` by @%s at %s, [details](%s)", emoji, logCommand(m.event.ObjectAttributes.Note),
+		m.event.User.Username, m.event.ObjectAttributes.CreatedAt, link)
+}
+
+// logCommand returns the command in a comment as it's shown in the command log, a short line of inline code
+func logCommand(note string) string {
+	command, _, _ := strings.Cut(strings.TrimSpace(note), "\n")
+	command = strings.ReplaceAll(strings.TrimSpace(command), "`", "'")
+	if runes := []rune(command); len(runes) > 80 {
+		command = string(runes[:80]) + "…"
+	}
+	return command
+}
+
+// commandLogEntries returns the entries of the command log in a status block, latest first
+func commandLogEntries(block string) []string {
+	var log string
+	if _, rest, ok := strings.Cut(block, logStart); ok {
+		log, _, _ = strings.Cut(rest, logEnd)
+	} else if i := strings.Index(block, "\n"+commandLogHeading+"\n"); i >= 0 {
+		// blocks written before the markers end with the log, its heading is on a line of its own,
+		// unlike any single line title in the status before it
+		log = block[i+1:]
+	} else {
+		return nil
+	}
+	var entries []string
+	for _, line := range strings.Split(log, "\n") {
+		if strings.HasPrefix(line, "- ") {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// renderCommandLog renders the command log of the latest commands, older pages are collapsed
+func renderCommandLog(entries []string) string {
+	if len(entries) > commandLogSize {
+		entries = entries[:commandLogSize]
+	}
+	pages := []string{commandLogHeading}
+	for start := 0; start < len(entries); start += commandLogPageSize {
+		end := min(start+commandLogPageSize, len(entries))
+		page := strings.Join(entries[start:end], "\n")
+		if start > 0 {
+			page = fmt.Sprintf("<details><summary>commands %d-%d</summary>\n\n%s\n\n</details>", start+1, end, page)
+		}
+		pages = append(pages, page)
+	}
+	return strings.Join(pages, "\n\n")
+}
+
+// statusBlock returns the status block in description and the content written by people before and after it.
+//
+// A description written by a version of branch-bot without markers is a status block as a whole, any other
+// description without markers is content written by people, kept after the status block.
+func statusBlock(description string) (block, before, after string) {
+	if start := strings.Index(description, blockStart); start >= 0 {
+		if end := strings.Index(description[start:], blockEnd); end >= 0 {
+			end += start
+			block = strings.Trim(description[start+len(blockStart):end], "\n")
+			return block, description[:start], description[end+len(blockEnd):]
+		}
+	}
+	if strings.HasPrefix(description, legacyStatusHeading+"\n") {
+		return description, "", ""
+	}
+	if description != "" {
+		after = "\n\n" + description
+	}
+	return "", "", after
+}
+
+// replaceStatusBlock replaces the status block in description with block followed by the command log,
+// putting it at the top if there is none yet
+func replaceStatusBlock(description, block, log string) string {
+	_, before, after := statusBlock(description)
+	// markers quoted from commands or titles must not end the block or the log early
+	escape := func(s string) string {
+		return strings.ReplaceAll(s, "<!-- branch-bot:", "&lt;!-- branch-bot:")
+	}
+	block = escape(block)
+	if log != "" {
+		block += "\n\n" + logStart + "\n" + escape(log) + "\n" + logEnd
+	}
+	return before + blockStart + "\n" + block + "\n" + blockEnd + after
+}
@@ -0,0 +1,130 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestReplaceStatusBlock(t *testing.T) {
+	block := "## Current Status\n\nstatus\n\n## Last Command\n> !bb status"
+	wrapped := blockStart + "\n" + block + "\n" + blockEnd
+	tests := []struct {
+		name        string
+		description string
+		want        string
+	}{
+		{"empty", "", wrapped},
+		{"legacy status", "## Current Status\n\nold status\n\n## Last Command\n> !bb add a", wrapped},
+		{"content without block", "Deploys to staging-3\n\n- [ ] QA", wrapped + "\n\nDeploys to staging-3\n\n- [ ] QA"},
+		{
+			"content around block",
+			"Notes\n\n" + blockStart + "\nold status\n" + blockEnd + "\n\n- [x] QA",
+			"Notes\n\n" + wrapped + "\n\n- [x] QA",
+		},
+		{"unterminated block", blockStart + "\nold status", wrapped + "\n\n" + blockStart + "\nold status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := replaceStatusBlock(tt.description, block, "")
+			assert.Equal(t, tt.want, got)
+			// replacing again keeps the content written by people
+			assert.Equal(t, got, replaceStatusBlock(got, block, ""))
+		})
+	}
+
+	t.Run("quoted markers", func(t *testing.T) {
+		got := replaceStatusBlock("Notes", "## Last Command\n> !bb status "+blockEnd+" injected", "")
+		assert.Equal(t, 1, strings.Count(got, blockEnd))
+		status, before, after := statusBlock(got)
+		assert.Contains(t, status, "injected")
+		assert.Equal(t, "", before)
+		assert.Equal(t, "\n\nNotes", after)
+	})
+}
+
+func TestCommandLog(t *testing.T) {
+	entries := make([]string, 0, commandLogSize+5)
+	for i := 1; i <= commandLogSize+5; i++ {
+		entries = append(entries, fmt.Sprintf("- :white_check_mark: `!bb status` by @dev%d", i))
+	}
+	log := renderCommandLog(entries)
+	assert.True(t, strings.HasPrefix(log, commandLogHeading+"\n\n- :white_check_mark: `!bb status` by @dev1\n"))
+	assert.Contains(t, log, "<details><summary>commands 11-20</summary>\n\n- :white_check_mark: `!bb status` by @dev11\n")
+	assert.Contains(t, log, "<summary>commands 41-50</summary>")
+	assert.NotContains(t, log, "@dev51")
+
+	// entries are read back from the status block, latest first
+	description := replaceStatusBlock("", "## Current Status\n\nstatus", log)
+	block, _, _ := statusBlock(description)
+	assert.Equal(t, entries[:commandLogSize], commandLogEntries(block))
+	assert.Nil(t, commandLogEntries("## Current Status\n\nstatus\n\n## Last Command\n> !bb add a"))
+
+	// templates can neither pollute nor drop the log
+	description = replaceStatusBlock("", "- not an entry\n"+commandLogHeading+"\n- nor this", log)
+	block, _, _ = statusBlock(description)
+	assert.Equal(t, entries[:commandLogSize], commandLogEntries(block))
+	description = replaceStatusBlock("", "", log)
+	block, _, _ = statusBlock(description)
+	assert.Equal(t, entries[:commandLogSize], commandLogEntries(block))
+
+	// blocks written before the log had markers end with it
+	assert.Equal(t, entries[:commandLogSize], commandLogEntries("## Current Status\n\nstatus\n\n"+log))
+
+	// commands quoting the markers don't end the log early
+	entry := "- :x: `!bb add " + logEnd + "` by @dev"
+	description = replaceStatusBlock("", "status", entry+"\n"+entries[0])
+	block, _, _ = statusBlock(description)
+	assert.Len(t, commandLogEntries(block), 2)
+}
+
+func TestPostResult(t *testing.T) {
+	var forbidden atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if forbidden.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v4/projects/1/issues/2/notes" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(body), "all goes well")
+		_, _ = w.Write([]byte(`{"id": 7}`))
+	}))
+	defer server.Close()
+	gl, err := gitlab.NewClient("token", append(apiClientOptions(), gitlab.WithBaseURL(server.URL))...)
+	require.NoError(t, err)
+	h := &Webhook{gl: gl}
+	event := &gitlab.IssueCommentEvent{ProjectID: 1}
+	event.Issue.IID = 2
+	event.ObjectAttributes.Note = "!bb status"
+	event.ObjectAttributes.URL = "https://gitlab.example.com/group/project/-/issues/2#note_6"
+	event.User = &gitlab.User{Username: "dev"}
+
+	// the command log links to the reply with the result
+	helper := MergeTrainViewGlHelper{event: event, resultURL: h.postResult(context.Background(), event, nil)}
+	assert.Equal(t, "https://gitlab.example.com/group/project/-/issues/2#note_7", helper.resultURL)
+	assert.Contains(t, helper.commandLogEntry(), "`!bb status` by @dev")
+	assert.Contains(t, helper.commandLogEntry(), "[details](https://gitlab.example.com/group/project/-/issues/2#note_7)")
+
+	// or to the command if replying failed
+	forbidden.Store(true)
+	assert.Equal(t, event.ObjectAttributes.URL, h.postResult(context.Background(), event, nil))
+}
+
+func TestLogCommand(t *testing.T) {
+	assert.Equal(t, "!bb add feature", logCommand("  !bb add feature\nplease"))
+	assert.Equal(t, "!bb status 'rm -rf'", logCommand("!bb status `rm -rf`"))
+	assert.Equal(t, "!bb status "+strings.Repeat("x", 69)+"…", logCommand("!bb status "+strings.Repeat("x", 100)))
+}
@@ -1,10 +1,13 @@
 package gitlab
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/jizhilong/branch-bot/config"
 	"github.com/jizhilong/branch-bot/core"
 	"github.com/jizhilong/branch-bot/git"
+	"github.com/jizhilong/branch-bot/models"
 	"github.com/xanzy/go-gitlab"
 	"io"
 	"log"
@@ -12,48 +15,79 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"regexp"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 )
 
 // Webhook handles HTTP requests for branch-bot
 type Webhook struct {
 	// port is the port number to listen on
 	port int
-	// repoDir is the directory where repositories are stored
-	repoDir string
+	// repos manages the clones of repositories
+	repos *git.Manager
 	// glToken is the GitLab access token
 	glToken string
 	// branchNamePrefix is the prefix for the output branch name
 	branchNamePrefix string
 	// gl is the GitLab client
 	gl *gitlab.Client
+	// cfg holds the settings of projects
+	cfg *config.Config
+	// ctx is canceled when the server shuts down, commands being processed are canceled with it
+	ctx context.Context
+	// signers and sshTransports hold the keys loaded so far, keys are loaded on first use
+	signers       map[config.Signing]*git.Signer
+	sshTransports map[config.SSH]*git.SSHTransport
+	keysMu        sync.Mutex
+	// templates are the issue templates by path, the default one by the empty path
+	templates map[string]*template.Template
+	// lookups caches GitLab lookups of views
+	lookups *lookupCache
 }
 
 // NewWebhook creates a new server instance
-func NewWebhook(gitlabUrl, gitlabToken, repoDir, branchNamePrefix string, port int) (*Webhook, error) {
-	if port <= 0 {
+func NewWebhook(cfg *config.Config) (*Webhook, error) {
+	if cfg.ListenPort <= 0 {
 		return nil, errors.New("invalid port number")
 	}
-	if err := os.MkdirAll(repoDir, 0755); err != nil {
+	if err := os.MkdirAll(cfg.RepoDirectory, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create repo directory: %w", err)
 	}
-	gl, err := gitlab.NewClient(gitlabToken, gitlab.WithBaseURL(gitlabUrl))
+	gl, err := gitlab.NewClient(cfg.GitlabToken, append(apiClientOptions(), gitlab.WithBaseURL(cfg.GitlabUrl))...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gitlab client: %w", err)
 	}
+	templates, err := loadIssueTemplates(cfg)
+	if err != nil {
+		return nil, err
+	}
+	repos := git.NewManager(cfg.RepoDirectory, git.ManagerOptions{
+		DiskBudget:          cfg.RepoDiskBudget,
+		IdleTimeout:         cfg.RepoIdleTimeout,
+		MaintenanceInterval: cfg.RepoMaintenanceInterval,
+	})
 	return &Webhook{
-		port:             port,
-		repoDir:          repoDir,
-		glToken:          gitlabToken,
-		branchNamePrefix: branchNamePrefix,
+		port:             cfg.ListenPort,
+		repos:            repos,
+		glToken:          cfg.GitlabToken,
+		branchNamePrefix: cfg.BranchNamePrefix,
 		gl:               gl,
+		cfg:              cfg,
+		ctx:              context.Background(),
+		signers:          make(map[config.Signing]*git.Signer),
+		sshTransports:    make(map[config.SSH]*git.SSHTransport),
+		templates:        templates,
+		lookups:          newLookupCache(),
 	}, nil
 }
 
 type Command interface {
 	CommandName() string
 	String() string
-	Process(h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator)
+	Process(ctx context.Context, h *Webhook, event *gitlab.IssueCommentEvent, logger *slog.Logger, operator *core.MergeTrainOperator)
 }
 
 // ParseCommand parses a command from issue comment
@@ -64,32 +98,97 @@ func ParseCommand(comment string) (Command, error) {
 		return nil, fmt.Errorf("invalid command format")
 	}
 	comment = strings.TrimPrefix(comment, "!bb ")
-	parts := strings.Split(comment, " ")
+	parts := strings.Fields(comment)
 	if len(parts) < 1 {
 		return nil, fmt.Errorf("missing command")
 	}
-	commandName := strings.TrimSpace(parts[0])
+	commandName := parts[0]
 	switch commandName {
-	case "add", "remove":
-		if len(parts) != 2 {
+	case "add":
+		cmd := &AddCommand{}
+		for i := 1; i < len(parts); i++ {
+			switch {
+			case parts[i] == "--after":
+				if i+1 >= len(parts) {
+					return nil, fmt.Errorf("missing branch name after --after")
+				}
+				i++
+				cmd.After = append(cmd.After, strings.Split(parts[i], ",")...)
+			case cmd.BranchName == "":
+				cmd.BranchName = parts[i]
+			default:
+				return nil, fmt.Errorf("invalid number of arguments, expected 1 branch name")
+			}
+		}
+		if cmd.BranchName == "" {
+			return nil, fmt.Errorf("invalid number of arguments, expected 1 branch name")
+		}
+		return cmd, nil
+	case "remove":
+		cmd := &RemoveCommand{}
+		for _, part := range parts[1:] {
+			switch {
+			case part == "--cascade":
+				cmd.Cascade = true
+			case cmd.BranchName == "":
+				cmd.BranchName = part
+			default:
+				return nil, fmt.Errorf("invalid number of arguments, expected 1 branch name")
+			}
+		}
+		if cmd.BranchName == "" {
 			return nil, fmt.Errorf("invalid number of arguments, expected 1 branch name")
 		}
-		if commandName == "add" {
-			return &AddCommand{BranchName: parts[1]}, nil
-		} else {
-			return &RemoveCommand{BranchName: parts[1]}, nil
+		return cmd, nil
+	case "resolve":
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid number of arguments, expected 2 branch names and 1 commit")
 		}
+		// the resolution may be pushed to any branch, it's fetched by its id, which can't be abbreviated
+		if !fullCommitID.MatchString(parts[3]) {
+			return nil, fmt.Errorf("invalid commit %s, expected a full 40 character commit id", parts[3])
+		}
+		return &ResolveCommand{Branches: [2]string{parts[1], parts[2]}, Commit: strings.ToLower(parts[3])}, nil
 	case "status":
 		return StatusCommand("status"), nil
+	case "diff":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid number of arguments, expected 1 branch name")
+		}
+		return &DiffCommand{BranchName: parts[1]}, nil
 	default:
 		return nil, fmt.Errorf("unknown command")
 	}
 }
 
-// Start starts the HTTP server
-func (h *Webhook) Start() error {
+// fullCommitID matches unabbreviated SHA-1 and SHA-256 commit ids
+var fullCommitID = regexp.MustCompile(`^(?i)([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Start starts the HTTP server, which shuts down once ctx is done
+func (h *Webhook) Start(ctx context.Context) error {
+	h.ctx = ctx
+	go h.repos.Run(ctx)
 	http.HandleFunc("/webhook", h.handleWebhook)
-	return http.ListenAndServe(fmt.Sprintf(":%d", h.port), nil)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", h.port)}
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("Failed to shut down server", "error", err)
+		}
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// reportTimeout limits reporting the result of a command to the issue
+const reportTimeout = 30 * time.Second
+
+// reportContext returns the context for reporting the result of a command running with ctx. It outlives ctx,
+// so that a command running out of time still reports why.
+func reportContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
 }
 
 // handleWebhook handles GitLab webhook events
@@ -126,13 +225,19 @@ func (h *Webhook) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			"project_id", e.ProjectID,
 			"issue_id", e.Issue.IID,
 		)
-		operator, err := h.getOperator(e.ProjectID, e.Issue.IID, e.Project.PathWithNamespace, e.Project.GitHTTPURL)
+		// commands outlive the request, GitLab gives up waiting for the response long before git is done
+		ctx, cancel := context.WithTimeout(h.ctx, h.cfg.CommandTimeout)
+		defer cancel()
+		operator, release, err := h.getOperator(ctx, e.ProjectID, e.Issue.IID, e.Project.PathWithNamespace, e.Project.GitHTTPURL, e.Project.GitSSHURL, e.Project.DefaultBranch)
 		if err != nil {
 			h.reply(e, fmt.Sprintf("failed to initialize repo: %s", err))
 			return
 		}
+		defer release()
 		logger.Info("Handling command", "command", cmd.String())
-		cmd.Process(h, e, logger, operator)
+		cmd.Process(ctx, h, e, logger, operator)
+	case *gitlab.PipelineEvent:
+		h.handlePipeline(e)
 	default:
 		slog.Warn("Unknown event type", "type", fmt.Sprintf("%T", e))
 		return
@@ -155,7 +260,7 @@ func (h *Webhook) parseGitlabEvent(r *http.Request) (interface{}, error) {
 	}
 
 	eventType := gitlab.EventType(event)
-	if eventType != gitlab.EventTypeNote {
+	if eventType != gitlab.EventTypeNote && eventType != gitlab.EventTypePipeline {
 		return nil, errors.New("event not defined to be parsed")
 	}
 
@@ -167,19 +272,120 @@ func (h *Webhook) parseGitlabEvent(r *http.Request) (interface{}, error) {
 	return gitlab.ParseWebhook(eventType, payload)
 }
 
-func (h *Webhook) getOperator(projectId, issueIID int, pathWithNameSpace, projectUrl string) (*core.MergeTrainOperator, error) {
-	u, err := url.Parse(projectUrl)
-	if err != nil {
-		slog.Error("Failed to parse project URL", "error", err)
-		return nil, fmt.Errorf("invalid project URL")
+// getOperator loads the merge train of an issue from the clone of the project, the clone is reserved for
+// the caller until release is called. Members are compared with defaultBranch in the view.
+func (h *Webhook) getOperator(ctx context.Context, projectId, issueIID int, pathWithNameSpace, httpUrl, sshUrl, defaultBranch string) (operator *core.MergeTrainOperator, release func(), err error) {
+	project := h.cfg.Project(pathWithNameSpace)
+	opts := git.Options{
+		MergeStrategies: project.MergeStrategies,
+		Timeouts: git.Timeouts{
+			Clone: time.Duration(project.Timeouts.Clone),
+			Fetch: time.Duration(project.Timeouts.Fetch),
+			Merge: time.Duration(project.Timeouts.Merge),
+			Push:  time.Duration(project.Timeouts.Push),
+		},
+		CloneFilter: project.CloneFilter,
+		Reference:   project.ReferenceRepository,
 	}
-	remoteUrl := fmt.Sprintf("%s://branch-bot:%s@%s%s", u.Scheme, h.glToken, u.Host, u.Path)
-	repoPath := fmt.Sprintf("%s/%s", h.repoDir, pathWithNameSpace)
-	if repo, err := git.SyncRepo(repoPath, remoteUrl); err != nil {
-		slog.Error("Failed to sync repo", "error", err)
-		return nil, fmt.Errorf("failed to sync repo")
+	var remoteUrl string
+	if project.Transport == config.TransportSSH {
+		if sshUrl == "" {
+			return nil, nil, fmt.Errorf("project has no ssh URL")
+		}
+		remoteUrl = sshUrl
+		opts.SSH, err = h.getSSHTransport(*project.SSH)
+		if err != nil {
+			slog.Error("Failed to load ssh key", "error", err)
+			return nil, nil, fmt.Errorf("failed to load ssh key")
+		}
 	} else {
-		branchName := fmt.Sprintf("%s%d", h.branchNamePrefix, issueIID)
-		return core.LoadMergeTrainOperator(repo, branchName, projectId, issueIID)
+		u, err := url.Parse(httpUrl)
+		if err != nil {
+			slog.Error("Failed to parse project URL", "error", err)
+			return nil, nil, fmt.Errorf("invalid project URL")
+		}
+		remoteUrl = fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, u.Path)
+		opts.Credentials = &git.Credentials{Username: "branch-bot", Password: h.glToken}
+	}
+	if project.Identity != nil {
+		opts.Identity = &git.Identity{Name: project.Identity.Name, Email: project.Identity.Email}
+	}
+	if project.Signing != nil {
+		// never fall back to unsigned commits, they'd be rejected where signatures are required
+		opts.Signer, err = h.getSigner(ctx, *project.Signing)
+		if err != nil {
+			slog.Error("Failed to load signing key", "error", err)
+			return nil, nil, fmt.Errorf("failed to load signing key")
+		}
+	}
+	repo, release, err := h.repos.Acquire(ctx, pathWithNameSpace, remoteUrl, opts)
+	if err != nil {
+		slog.Error("Failed to sync repo", "error", err)
+		var timeoutErr *models.GitTimeoutError
+		if errors.As(err, &timeoutErr) {
+			return nil, nil, fmt.Errorf("failed to sync repo: %s", timeoutErr.AsMarkdown())
+		}
+		return nil, nil, fmt.Errorf("failed to sync repo")
+	}
+	branchName := fmt.Sprintf("%s%d", h.branchNamePrefix, issueIID)
+	// a fresh clone only has the state of the merge train in the remote bb branch
+	refs := []string{"refs/heads/" + branchName}
+	if defaultBranch != "" {
+		refs = append(refs, "refs/heads/"+defaultBranch)
+	}
+	if err := repo.Fetch(ctx, refs...); err != nil {
+		release()
+		slog.Error("Failed to fetch bb branch", "error", err)
+		return nil, nil, fmt.Errorf("failed to fetch %s", branchName)
+	}
+	operator, err = core.LoadMergeTrainOperator(ctx, repo, branchName, projectId, issueIID)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	operator.SetValidations(project.Validations, time.Duration(project.ValidationTimeout))
+	operator.SetBaseBranch(defaultBranch)
+	return operator, release, nil
+}
+
+// viewHelper returns the helper saving the merge train view of the issue in event, with the result of its command
+func (h *Webhook) viewHelper(ctx context.Context, event *gitlab.IssueCommentEvent, err error) *MergeTrainViewGlHelper {
+	return &MergeTrainViewGlHelper{
+		ctx:      ctx,
+		gl:       h.gl,
+		event:    event,
+		err:      err,
+		cache:    h.lookups,
+		template: h.templates[h.cfg.Project(event.Project.PathWithNamespace).IssueTemplate],
+	}
+}
+
+// getSigner returns the signer of a signing key, loading the key on first use
+func (h *Webhook) getSigner(ctx context.Context, signing config.Signing) (*git.Signer, error) {
+	h.keysMu.Lock()
+	defer h.keysMu.Unlock()
+	if signer, ok := h.signers[signing]; ok {
+		return signer, nil
+	}
+	signer, err := git.NewSigner(ctx, signing.Format, signing.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	h.signers[signing] = signer
+	return signer, nil
+}
+
+// getSSHTransport returns the ssh transport of a deploy key, loading the key on first use
+func (h *Webhook) getSSHTransport(ssh config.SSH) (*git.SSHTransport, error) {
+	h.keysMu.Lock()
+	defer h.keysMu.Unlock()
+	if transport, ok := h.sshTransports[ssh]; ok {
+		return transport, nil
+	}
+	transport, err := git.NewSSHTransport(ssh.KeyFile, ssh.KnownHostsFile)
+	if err != nil {
+		return nil, err
 	}
+	h.sshTransports[ssh] = transport
+	return transport, nil
 }
@@ -1 +1,84 @@
 package gitlab
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		want    Command
+		wantErr bool
+	}{
+		{"not a command", "hello", nil, true},
+		{"unknown command", "!bb foo", nil, true},
+		{"status", "!bb status", StatusCommand("status"), false},
+		{"add", "!bb add feature", &AddCommand{BranchName: "feature"}, false},
+		{"add merge request", "!bb  add  !12 ", &AddCommand{BranchName: "!12"}, false},
+		{"add after", "!bb add stacked --after feature", &AddCommand{BranchName: "stacked", After: []string{"feature"}}, false},
+		{"add after many", "!bb add stacked --after a,b --after !3", &AddCommand{BranchName: "stacked", After: []string{"a", "b", "!3"}}, false},
+		{"add after missing", "!bb add stacked --after", nil, true},
+		{"add without branch", "!bb add", nil, true},
+		{"add two branches", "!bb add a b", nil, true},
+		{"remove", "!bb remove feature", &RemoveCommand{BranchName: "feature"}, false},
+		{"remove cascade", "!bb remove feature --cascade", &RemoveCommand{BranchName: "feature", Cascade: true}, false},
+		{"resolve", "!bb resolve a b 1234567890ABCDEF1234567890abcdef12345678",
+			&ResolveCommand{Branches: [2]string{"a", "b"}, Commit: "1234567890abcdef1234567890abcdef12345678"}, false},
+		{"resolve abbreviated commit", "!bb resolve a b 1234abcd", nil, true},
+		{"resolve without commit", "!bb resolve a b", nil, true},
+		{"diff", "!bb diff feature", &DiffCommand{BranchName: "feature"}, false},
+		{"diff without branch", "!bb diff", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.comment)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPipelineIssueIID(t *testing.T) {
+	h := &Webhook{branchNamePrefix: "bb-branches/"}
+	tests := []struct {
+		ref  string
+		want int
+		ok   bool
+	}{
+		{"bb-branches/42", 42, true},
+		{"bb-branches/0", 0, false},
+		{"bb-branches/42a", 0, false},
+		{"bb-resolve/42/feature", 0, false},
+		{"main", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, ok := h.pipelineIssueIID(tt.ref)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReportContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	// results of commands running out of time are still reported, within a deadline of their own
+	reportCtx, cancel := reportContext(ctx)
+	defer cancel()
+	assert.NoError(t, reportCtx.Err())
+	deadline, ok := reportCtx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(reportTimeout), deadline, time.Second)
+}
@@ -15,6 +15,10 @@ type MergeTrain struct {
 	IssueIID   int
 	BranchName string
 	Members    []MergeTrainItem
+	// Resolutions are commits registered to resolve the conflicts between pairs of members
+	Resolutions []ConflictResolution `json:",omitempty"`
+	// AutoResolved are the conflicts resolved automatically by merge strategies in the bb commit
+	AutoResolved []FileAutoResolution `json:",omitempty"`
 }
 
 // MergeTrainItem represents a member branch in merge train
@@ -22,6 +26,22 @@ type MergeTrainItem struct {
 	ProjectID    int    // GitLab project ID
 	Branch       string // branch name
 	MergedCommit string // commit that has been merged into bb branch
+	// DependsOn are the members this branch builds on, which are always merged before it
+	DependsOn []string `json:",omitempty"`
+}
+
+// ConflictResolution is a commit registered to resolve the conflict between two branches.
+//
+// The commit is usually a merge of both branches with the conflicts resolved by hand, it is merged
+// in place of the later one of the two branches whenever the merge train is rebuilt.
+type ConflictResolution struct {
+	Branches [2]string // the pair of conflicting branches
+	Commit   string    // commit that contains both branches with conflicts resolved
+}
+
+// Involves reports whether the resolution is registered for the given branch
+func (r ConflictResolution) Involves(branch string) bool {
+	return r.Branches[0] == branch || r.Branches[1] == branch
 }
 
 // NewMergeTrain creates a new merge train
@@ -54,6 +74,97 @@ func (mt *MergeTrain) RemoveMember(branch string) {
 	mt.Members = newMembers
 }
 
+// ResolutionsWith returns the conflict resolutions with res registered,
+// replacing the existing resolution of the same pair of branches if any.
+func (mt *MergeTrain) ResolutionsWith(res ConflictResolution) []ConflictResolution {
+	resolutions := make([]ConflictResolution, 0, len(mt.Resolutions)+1)
+	for _, r := range mt.Resolutions {
+		if !(r.Involves(res.Branches[0]) && r.Involves(res.Branches[1])) {
+			resolutions = append(resolutions, r)
+		}
+	}
+	return append(resolutions, res)
+}
+
+// ResolutionsWithout returns the conflict resolutions not involving the given branch
+func (mt *MergeTrain) ResolutionsWithout(branch string) []ConflictResolution {
+	resolutions := make([]ConflictResolution, 0, len(mt.Resolutions))
+	for _, r := range mt.Resolutions {
+		if !r.Involves(branch) {
+			resolutions = append(resolutions, r)
+		}
+	}
+	return resolutions
+}
+
+// SortMembers orders members so that every member comes after the members it depends on,
+// otherwise keeping their original order
+func SortMembers(members []MergeTrainItem) ([]MergeTrainItem, error) {
+	index := make(map[string]int, len(members))
+	for i, m := range members {
+		index[m.Branch] = i
+	}
+	for _, m := range members {
+		for _, dep := range m.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("branch %s depends on %s, which is not a member of merge train", m.Branch, dep)
+			}
+		}
+	}
+
+	sorted := make([]MergeTrainItem, 0, len(members))
+	placed := make([]bool, len(members))
+	for len(sorted) < len(members) {
+		// Pick the first member whose dependencies are all placed
+		next := -1
+		for i, m := range members {
+			if placed[i] {
+				continue
+			}
+			ready := true
+			for _, dep := range m.DependsOn {
+				if !placed[index[dep]] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var cyclic []string
+			for i, m := range members {
+				if !placed[i] {
+					cyclic = append(cyclic, m.Branch)
+				}
+			}
+			return nil, fmt.Errorf("circular dependencies between %s", strings.Join(cyclic, ", "))
+		}
+		placed[next] = true
+		sorted = append(sorted, members[next])
+	}
+	return sorted, nil
+}
+
+// Dependents returns the members depending on the given branch, directly or indirectly
+func Dependents(members []MergeTrainItem, branch string) []string {
+	dependents := []string{}
+	required := map[string]bool{branch: true}
+	// members are sorted by dependencies, so dependents always come after what they depend on
+	for _, m := range members {
+		for _, dep := range m.DependsOn {
+			if required[dep] {
+				required[m.Branch] = true
+				dependents = append(dependents, m.Branch)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
 // GenerateCommitMessage creates a commit message for the bb branch
 func (mt *MergeTrain) GenerateCommitMessage() string {
 	data, err := json.MarshalIndent(mt, "", "  ")
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -29,7 +30,7 @@ func TestGenerateAndLoadCommitMessage(t *testing.T) {
 	}
 
 	for i, member := range mtOriginal.Members {
-		if member != mtLoaded.Members[i] {
+		if !reflect.DeepEqual(member, mtLoaded.Members[i]) {
 			t.Errorf("Loaded member does not match original: got %v, want %v", mtLoaded.Members[i], member)
 		}
 	}
@@ -49,8 +50,107 @@ func TestGenerateAndLoadCommitMessage(t *testing.T) {
 	}
 
 	for i, member := range mtOriginal.Members {
-		if member != mtLoaded.Members[i] {
+		if !reflect.DeepEqual(member, mtLoaded.Members[i]) {
 			t.Errorf("Loaded member after removal does not match original: got %v, want %v", mtLoaded.Members[i], member)
 		}
 	}
 }
+
+func TestMergeTrainResolutions(t *testing.T) {
+	mt := NewMergeTrain(123, 456, "bb-branches/1")
+	mt.AddMember("feature-1", "abc123")
+	mt.AddMember("feature-2", "def456")
+	mt.Resolutions = mt.ResolutionsWith(ConflictResolution{Branches: [2]string{"feature-1", "feature-2"}, Commit: "111111"})
+	mt.Resolutions = mt.ResolutionsWith(ConflictResolution{Branches: [2]string{"feature-2", "feature-3"}, Commit: "222222"})
+
+	// registering the same pair again replaces the previous resolution
+	mt.Resolutions = mt.ResolutionsWith(ConflictResolution{Branches: [2]string{"feature-2", "feature-1"}, Commit: "333333"})
+	if len(mt.Resolutions) != 2 || mt.Resolutions[1].Commit != "333333" {
+		t.Fatalf("unexpected resolutions after replacing: %v", mt.Resolutions)
+	}
+
+	mtLoaded, err := LoadFromCommitMessage(mt.GenerateCommitMessage())
+	if err != nil {
+		t.Fatalf("LoadFromCommitMessage() error = %v", err)
+	}
+	if len(mtLoaded.Resolutions) != 2 || mtLoaded.Resolutions[0] != mt.Resolutions[0] || mtLoaded.Resolutions[1] != mt.Resolutions[1] {
+		t.Errorf("Loaded resolutions do not match original: got %v, want %v", mtLoaded.Resolutions, mt.Resolutions)
+	}
+
+	resolutions := mt.ResolutionsWithout("feature-3")
+	if len(resolutions) != 1 || resolutions[0].Commit != "333333" {
+		t.Errorf("unexpected resolutions without feature-3: %v", resolutions)
+	}
+}
+
+func TestSortMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []MergeTrainItem
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "no dependencies",
+			members: []MergeTrainItem{
+				{Branch: "a"}, {Branch: "b"}, {Branch: "c"},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "updated base moves dependents after it",
+			members: []MergeTrainItem{
+				{Branch: "b", DependsOn: []string{"a"}}, {Branch: "c"}, {Branch: "d", DependsOn: []string{"b"}}, {Branch: "a"},
+			},
+			want: []string{"c", "a", "b", "d"},
+		},
+		{
+			name: "unknown dependency",
+			members: []MergeTrainItem{
+				{Branch: "b", DependsOn: []string{"a"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "circular dependencies",
+			members: []MergeTrainItem{
+				{Branch: "a", DependsOn: []string{"b"}}, {Branch: "b", DependsOn: []string{"a"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := SortMembers(tt.members)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("SortMembers() expected error, got %v", sorted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SortMembers() error = %v", err)
+			}
+			var got []string
+			for _, m := range sorted {
+				got = append(got, m.Branch)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SortMembers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDependents(t *testing.T) {
+	members := []MergeTrainItem{
+		{Branch: "a"}, {Branch: "b", DependsOn: []string{"a"}}, {Branch: "c"}, {Branch: "d", DependsOn: []string{"b", "c"}},
+	}
+	if got := Dependents(members, "a"); !reflect.DeepEqual(got, []string{"b", "d"}) {
+		t.Errorf("Dependents(a) = %v, want [b d]", got)
+	}
+	if got := Dependents(members, "d"); len(got) != 0 {
+		t.Errorf("Dependents(d) = %v, want none", got)
+	}
+}
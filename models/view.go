@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // view.go contains types for rendering merge train status.
@@ -11,10 +12,19 @@ import (
 
 // MergeTrainView represents a merge train with display information
 type MergeTrainView struct {
-	Branch  string
-	URL     string
-	Commit  *CommitView
-	Members []MemberView
+	Branch   string
+	URL      string
+	Commit   *CommitView
+	Pipeline *PipelineView // latest pipeline of the bb commit, if any
+	Members  []MemberView
+	// Resolutions are the registered conflict resolutions
+	Resolutions []ResolutionView
+	// AutoResolved are the conflicts resolved automatically by merge strategies
+	AutoResolved []FileAutoResolution
+	// Hotspots are the files modified by more than one member
+	Hotspots []HotspotView
+	// BaseBranch is the branch members are compared with, empty if unknown
+	BaseBranch string
 }
 
 // MemberView represents a member branch with display information
@@ -24,6 +34,60 @@ type MemberView struct {
 	MergeRequest *MergeRequestView // optional, only if branch is from MR
 	MergedCommit *CommitView       // commit that has been merged
 	LatestCommit *CommitView       // latest commit on branch
+	Pipeline     *PipelineView     // latest pipeline of merged commit, if any
+	DiffStat     *DiffStatView     // changes of merged commit since the merge base of all members
+	DependsOn    []string          // members this branch builds on
+	Warnings     []string          // lookups of the member that failed
+	Ahead        int               // commits of the merged commit missing in the base branch
+	Behind       int               // commits of the base branch missing in the merged commit
+	Age          time.Duration     // age of the merged commit
+	// MergedUpstream is set if the merged commit is already contained in the base branch, so the member is redundant
+	MergedUpstream bool
+	// Updates are the changes of the latest commit since the merged commit, if the member is outdated
+	Updates *MemberDiffView
+}
+
+// MemberDiffView summarizes what a member changed between two commits, usually its merged commit and latest commit
+type MemberDiffView struct {
+	Branch       string
+	From         *CommitView
+	To           *CommitView
+	Commits      []CommitView // latest first, truncated
+	TotalCommits int
+	Files        []FileDiffStat // truncated
+	TotalFiles   int
+	Added        int // lines added in all files
+	Deleted      int // lines deleted in all files
+	CompareURL   string
+}
+
+// DiffStatView summarizes the changes of a member
+type DiffStatView struct {
+	Files   int
+	Added   int
+	Deleted int
+}
+
+// HotspotView represents a file modified by more than one member,
+// which deserves a review for semantic conflicts even if git merges it cleanly
+type HotspotView struct {
+	Path    string
+	Changes []HotspotChange
+}
+
+// HotspotChange represents the changes of a member to a hotspot file
+type HotspotChange struct {
+	Branch  string
+	Added   int
+	Deleted int
+	Binary  bool
+}
+
+// ResolutionView represents a registered conflict resolution with display information
+type ResolutionView struct {
+	Branches [2]string
+	Commit   *CommitView
+	Outdated bool // the resolution doesn't contain the merged commits of its branches any more
 }
 
 // MergeRequestView contains merge request display information
@@ -34,10 +98,68 @@ type MergeRequestView struct {
 	Author string
 }
 
+// PipelineView contains CI pipeline display information
+type PipelineView struct {
+	Status string // GitLab pipeline status, like success, failed or running
+	URL    string
+}
+
+// pipelineEmojis are the emojis of pipeline statuses in badges
+var pipelineEmojis = map[string]string{
+	"success":              ":white_check_mark:",
+	"failed":               ":x:",
+	"running":              ":arrows_counterclockwise:",
+	"pending":              ":hourglass:",
+	"created":              ":hourglass:",
+	"preparing":            ":hourglass:",
+	"waiting_for_resource": ":hourglass:",
+	"scheduled":            ":alarm_clock:",
+	"manual":               ":raised_hand:",
+	"canceled":             ":no_entry_sign:",
+	"skipped":              ":fast_forward:",
+}
+
+// Badge renders the pipeline as a status badge linking to the pipeline
+func (p *PipelineView) Badge() string {
+	if p == nil {
+		return "null"
+	}
+	emoji, ok := pipelineEmojis[p.Status]
+	if !ok {
+		emoji = ":grey_question:"
+	}
+	return fmt.Sprintf("[%s %s](%s)", emoji, p.Status, p.URL)
+}
+
+// mermaidClass returns the mermaid class of nodes coloured by the pipeline status
+func (p *PipelineView) mermaidClass() string {
+	if p == nil {
+		return ""
+	}
+	switch p.Status {
+	case "success", "failed", "running":
+		return p.Status
+	case "pending", "created", "preparing", "waiting_for_resource", "scheduled":
+		return "pending"
+	default:
+		return "inactive"
+	}
+}
+
+// mermaidClassDefs are the styles of nodes by pipeline status
+var mermaidClassDefs = map[string]string{
+	"success":  "classDef success fill:#c3e6cb,stroke:#28a745;",
+	"failed":   "classDef failed fill:#f5c6cb,stroke:#dc3545;",
+	"running":  "classDef running fill:#b8daff,stroke:#007bff;",
+	"pending":  "classDef pending fill:#ffeeba,stroke:#ffc107;",
+	"inactive": "classDef inactive fill:#e2e3e5,stroke:#6c757d;",
+}
+
 // CommitView contains commit display information
 type CommitView struct {
-	SHA string // full SHA
-	URL string
+	SHA   string // full SHA
+	URL   string
+	Title string // first line of the commit message, only set in lists of commits
 }
 
 // MarkdownAble is an interface for types that can be rendered as markdown
@@ -81,6 +203,35 @@ func (v *MergeTrainView) RenderMermaid() string {
 		}
 	}
 
+	// Add dependency edges, from each member to the members it builds on
+	index := make(map[string]int, len(v.Members))
+	for idx, m := range v.Members {
+		index[m.Branch] = idx
+	}
+	for idx, m := range v.Members {
+		for _, dep := range m.DependsOn {
+			if depIdx, ok := index[dep]; ok {
+				graph = append(graph, fmt.Sprintf("m%d -. builds on .-> m%d;", idx, depIdx))
+			}
+		}
+	}
+
+	// Color nodes by pipeline status
+	nodes := make(map[string][]string)
+	if class := v.Pipeline.mermaidClass(); class != "" {
+		nodes[class] = append(nodes[class], "BB")
+	}
+	for idx, m := range v.Members {
+		if class := m.Pipeline.mermaidClass(); class != "" {
+			nodes[class] = append(nodes[class], fmt.Sprintf("m%d", idx))
+		}
+	}
+	for _, class := range []string{"success", "failed", "running", "pending", "inactive"} {
+		if len(nodes[class]) > 0 {
+			graph = append(graph, mermaidClassDefs[class], fmt.Sprintf("class %s %s;", strings.Join(nodes[class], ","), class))
+		}
+	}
+
 	// Add click events for links
 	graph = append(graph, fmt.Sprintf("click BB \"%s\" _blank", v.URL))
 	for idx, m := range v.Members {
@@ -102,8 +253,8 @@ func (v *MergeTrainView) RenderTable() string {
 
 	// Table header
 	table := []string{
-		"| Branch | Merge Request | Merged Commit | Latest Commit | Note |",
-		"| ------ | ------------ | ------------- | ------------- | ---- |",
+		"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+		"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
 	}
 
 	// Add bb branch status
@@ -111,7 +262,7 @@ func (v *MergeTrainView) RenderTable() string {
 	if v.Commit != nil {
 		trainCommit = fmt.Sprintf("[%s](%s)", v.Commit.SHA[:8], v.Commit.URL)
 	}
-	table = append(table, fmt.Sprintf("| [%s](%s) | null | null | %s |  |", v.Branch, v.URL, trainCommit))
+	table = append(table, fmt.Sprintf("| [%s](%s) | null | null | %s | null | null | %s |  |", v.Branch, v.URL, trainCommit, v.Pipeline.Badge()))
 
 	// Add member branches
 	for _, m := range v.Members {
@@ -142,8 +293,202 @@ func (v *MergeTrainView) RenderTable() string {
 			hint = fmt.Sprintf("Update to latest: `!bb add %s`", m.Branch)
 		}
 
-		table = append(table, fmt.Sprintf("| %s | %s | %s | %s | %s |", branch, mr, merged, latest, hint))
+		distance, age := "null", "null"
+		if m.MergedCommit != nil && v.BaseBranch != "" {
+			distance = fmt.Sprintf("↑%d ↓%d", m.Ahead, m.Behind)
+		}
+		if m.MergedCommit != nil && m.Age > 0 {
+			age = formatAge(m.Age)
+		}
+
+		notes := []string{}
+		if hint != "" {
+			notes = append(notes, hint)
+		}
+		if m.MergedUpstream {
+			notes = append(notes, fmt.Sprintf(":recycle: already merged into %s, remove it: `!bb remove %s`", v.BaseBranch, m.Branch))
+		}
+		for _, warning := range m.Warnings {
+			notes = append(notes, ":warning: "+tableCell(warning))
+		}
+
+		table = append(table, fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s | %s |", branch, mr, merged, latest, distance, age,
+			m.Pipeline.Badge(), strings.Join(notes, "<br>")))
 	}
 
 	return strings.Join(table, "\n")
 }
+
+// formatAge formats the age of a commit in the largest unit up to days, e.g. 45m, 5h or 3d
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+// tableCell escapes text for a cell of a markdown table, which can't span lines or contain pipes
+func tableCell(text string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(text)
+}
+
+// tableCode formats text as inline code in a cell of a markdown table, fenced by more backticks than it contains
+// in a row, so that paths with backticks or pipes keep the table intact
+func tableCode(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if longest > 0 {
+		// a code span starting or ending with a backtick needs spaces to tell it from the fence
+		text = " " + text + " "
+	}
+	return tableCell(fence + text + fence)
+}
+
+// RenderResolutions generates a markdown table of registered conflict resolutions
+func (v *MergeTrainView) RenderResolutions() string {
+	if len(v.Resolutions) == 0 {
+		return ""
+	}
+
+	table := []string{
+		"| Conflicting Branches | Resolution | Note |",
+		"| -------------------- | ---------- | ---- |",
+	}
+	for _, r := range v.Resolutions {
+		note := ""
+		if r.Outdated {
+			note = fmt.Sprintf("Outdated, merge the latest branches and run `!bb resolve %s %s <commit>` again",
+				r.Branches[0], r.Branches[1])
+		}
+		table = append(table, fmt.Sprintf("| `%s`, `%s` | [%s](%s) | %s |",
+			r.Branches[0], r.Branches[1], r.Commit.SHA[:8], r.Commit.URL, note))
+	}
+	return strings.Join(table, "\n")
+}
+
+// RenderAutoResolved generates a markdown list of conflicts resolved automatically by merge strategies
+func (v *MergeTrainView) RenderAutoResolved() string {
+	if len(v.AutoResolved) == 0 {
+		return ""
+	}
+
+	list := make([]string, 0, len(v.AutoResolved))
+	for _, r := range v.AutoResolved {
+		list = append(list, fmt.Sprintf("- `%s`: %s", r.Path, r.Strategy))
+	}
+	return strings.Join(list, "\n")
+}
+
+// RenderUpdates generates a collapsed diff summary of every outdated member
+func (v *MergeTrainView) RenderUpdates() string {
+	var sections []string
+	for _, m := range v.Members {
+		if m.Updates != nil {
+			sections = append(sections, fmt.Sprintf("<details><summary>%s: %s</summary>\n\n%s\n\n</details>",
+				m.Branch, m.Updates.Summary(), m.Updates.AsMarkdown()))
+		}
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// UpToDate reports whether there are no changes between the commits
+func (d *MemberDiffView) UpToDate() bool {
+	return d.TotalCommits == 0 && d.TotalFiles == 0
+}
+
+// Summary sums up the size of the diff in a line
+func (d *MemberDiffView) Summary() string {
+	return fmt.Sprintf("%s, %s changed (+%d -%d)",
+		plural(d.TotalCommits, "commit"), plural(d.TotalFiles, "file"), d.Added, d.Deleted)
+}
+
+// AsMarkdown renders the commits and the diffstat, linking to the compare view of GitLab if they are truncated
+func (d *MemberDiffView) AsMarkdown() string {
+	if d.UpToDate() {
+		return fmt.Sprintf("`%s` is up to date with [%s](%s)", d.Branch, d.From.SHA[:8], d.From.URL)
+	}
+	lines := []string{fmt.Sprintf("Changes of `%s` from [%s](%s) to [%s](%s), latest first:", d.Branch,
+		d.From.SHA[:8], d.From.URL, d.To.SHA[:8], d.To.URL), ""}
+	for _, c := range d.Commits {
+		lines = append(lines, fmt.Sprintf("- [%s](%s) %s", c.SHA[:8], c.URL, c.Title))
+	}
+	if more := d.TotalCommits - len(d.Commits); more > 0 {
+		lines = append(lines, fmt.Sprintf("- … %d more", more))
+	}
+	if len(d.Files) > 0 {
+		lines = append(lines, "", "| File | Added | Deleted |", "| ---- | ----- | ------- |")
+		for _, f := range d.Files {
+			if f.Binary {
+				lines = append(lines, fmt.Sprintf("| `%s` | binary | binary |", tableCell(f.Path)))
+			} else {
+				lines = append(lines, fmt.Sprintf("| `%s` | +%d | -%d |", tableCell(f.Path), f.Added, f.Deleted))
+			}
+		}
+		if more := d.TotalFiles - len(d.Files); more > 0 {
+			lines = append(lines, fmt.Sprintf("| … %d more | | |", more))
+		}
+	}
+	if d.CompareURL != "" && (len(d.Commits) < d.TotalCommits || len(d.Files) < d.TotalFiles) {
+		lines = append(lines, "", fmt.Sprintf("[Compare all changes on GitLab](%s)", d.CompareURL))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// plural formats a count of things, e.g. 1 commit or 3 commits
+func plural(n int, thing string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, thing)
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
+
+// RenderHotspots generates a markdown table of files modified by more than one member,
+// followed by the diffstat of each member
+func (v *MergeTrainView) RenderHotspots() string {
+	if len(v.Hotspots) == 0 {
+		return ""
+	}
+
+	table := []string{
+		"| File | Modified By |",
+		"| ---- | ----------- |",
+	}
+	for _, h := range v.Hotspots {
+		changes := make([]string, 0, len(h.Changes))
+		for _, c := range h.Changes {
+			if c.Binary {
+				changes = append(changes, fmt.Sprintf("`%s` (binary)", c.Branch))
+			} else {
+				changes = append(changes, fmt.Sprintf("`%s` (+%d -%d)", c.Branch, c.Added, c.Deleted))
+			}
+		}
+		table = append(table, fmt.Sprintf("| %s | %s |", tableCode(h.Path), strings.Join(changes, ", ")))
+	}
+
+	table = append(table,
+		"",
+		"<details><summary>diffstat per member</summary>",
+		"",
+		"| Branch | Files | Added | Deleted |",
+		"| ------ | ----- | ----- | ------- |",
+	)
+	for _, m := range v.Members {
+		if m.DiffStat != nil {
+			table = append(table, fmt.Sprintf("| %s | %d | +%d | -%d |", m.Branch, m.DiffStat.Files, m.DiffStat.Added, m.DiffStat.Deleted))
+		}
+	}
+	table = append(table, "", "</details>")
+	return strings.Join(table, "\n")
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestMergeTrainView_RenderMermaid(t *testing.T) {
@@ -90,6 +91,105 @@ func TestMergeTrainView_RenderMermaid(t *testing.T) {
 				"```",
 			}, "\n"),
 		},
+		{
+			name: "stacked branches",
+			view: MergeTrainView{
+				Branch: "bb-branches/42",
+				URL:    "https://gitlab.com/demo/project/-/tree/bb-branches/42",
+				Commit: &CommitView{
+					SHA: "f9e8d7c6b5a4321",
+					URL: "https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321",
+				},
+				Members: []MemberView{
+					{
+						Branch:    "feature/auth",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/auth",
+						MergedCommit: &CommitView{
+							SHA: "a1b2c3d4e5f6789",
+							URL: "https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789",
+						},
+					},
+					{
+						Branch:    "feature/auth-ui",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/auth-ui",
+						MergedCommit: &CommitView{
+							SHA: "b2c3d4e5f6789a",
+							URL: "https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a",
+						},
+						DependsOn: []string{"feature/auth"},
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"```mermaid",
+				"graph LR",
+				`m0("feature/auth") -- a1b2c3d4 --> BB[("bb-branches/42(f9e8d7c6)")];`,
+				`m1("feature/auth-ui") -- b2c3d4e5 --> BB;`,
+				`m1 -. builds on .-> m0;`,
+				`click BB "https://gitlab.com/demo/project/-/tree/bb-branches/42" _blank`,
+				`click m0 "https://gitlab.com/demo/project/-/tree/feature/auth" _blank`,
+				`click m1 "https://gitlab.com/demo/project/-/tree/feature/auth-ui" _blank`,
+				"```",
+			}, "\n"),
+		},
+		{
+			name: "pipelines",
+			view: MergeTrainView{
+				Branch: "bb-branches/42",
+				URL:    "https://gitlab.com/demo/project/-/tree/bb-branches/42",
+				Commit: &CommitView{
+					SHA: "f9e8d7c6b5a4321",
+					URL: "https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321",
+				},
+				Pipeline: &PipelineView{Status: "running", URL: "https://gitlab.com/demo/project/-/pipelines/3"},
+				Members: []MemberView{
+					{
+						Branch:    "feature/auth",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/auth",
+						MergedCommit: &CommitView{
+							SHA: "a1b2c3d4e5f6789",
+							URL: "https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789",
+						},
+						Pipeline: &PipelineView{Status: "success", URL: "https://gitlab.com/demo/project/-/pipelines/1"},
+					},
+					{
+						Branch:    "feature/ui",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/ui",
+						MergedCommit: &CommitView{
+							SHA: "b2c3d4e5f6789a",
+							URL: "https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a",
+						},
+						Pipeline: &PipelineView{Status: "failed", URL: "https://gitlab.com/demo/project/-/pipelines/2"},
+					},
+					{
+						Branch:    "feature/docs",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/docs",
+						MergedCommit: &CommitView{
+							SHA: "c3d4e5f6789ab",
+							URL: "https://gitlab.com/demo/project/-/commit/c3d4e5f6789ab",
+						},
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"```mermaid",
+				"graph LR",
+				`m0("feature/auth") -- a1b2c3d4 --> BB[("bb-branches/42(f9e8d7c6)")];`,
+				`m1("feature/ui") -- b2c3d4e5 --> BB;`,
+				`m2("feature/docs") -- c3d4e5f6 --> BB;`,
+				"classDef success fill:#c3e6cb,stroke:#28a745;",
+				"class m0 success;",
+				"classDef failed fill:#f5c6cb,stroke:#dc3545;",
+				"class m1 failed;",
+				"classDef running fill:#b8daff,stroke:#007bff;",
+				"class BB running;",
+				`click BB "https://gitlab.com/demo/project/-/tree/bb-branches/42" _blank`,
+				`click m0 "https://gitlab.com/demo/project/-/tree/feature/auth" _blank`,
+				`click m1 "https://gitlab.com/demo/project/-/tree/feature/ui" _blank`,
+				`click m2 "https://gitlab.com/demo/project/-/tree/feature/docs" _blank`,
+				"```",
+			}, "\n"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -133,10 +233,10 @@ func TestMergeTrainView_RenderTable(t *testing.T) {
 				},
 			},
 			want: strings.Join([]string{
-				"| Branch | Merge Request | Merged Commit | Latest Commit | Note |",
-				"| ------ | ------------ | ------------- | ------------- | ---- |",
-				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) |  |",
-				"| [main](https://gitlab.com/demo/project/-/tree/main) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | null |  |",
+				"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+				"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
+				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) | null | null | null |  |",
+				"| [main](https://gitlab.com/demo/project/-/tree/main) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | null | null | null | null |  |",
 			}, "\n"),
 		},
 		{
@@ -164,10 +264,110 @@ func TestMergeTrainView_RenderTable(t *testing.T) {
 				},
 			},
 			want: strings.Join([]string{
-				"| Branch | Merge Request | Merged Commit | Latest Commit | Note |",
-				"| ------ | ------------ | ------------- | ------------- | ---- |",
-				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) |  |",
-				"| [feature/auth](https://gitlab.com/demo/project/-/tree/feature/auth) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | [b2c3d4e5](https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a) | Update to latest: `!bb add feature/auth` |",
+				"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+				"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
+				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) | null | null | null |  |",
+				"| [feature/auth](https://gitlab.com/demo/project/-/tree/feature/auth) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | [b2c3d4e5](https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a) | null | null | null | Update to latest: `!bb add feature/auth` |",
+			}, "\n"),
+		},
+		{
+			name: "base distance",
+			view: MergeTrainView{
+				Branch: "bb-branches/42",
+				URL:    "https://gitlab.com/demo/project/-/tree/bb-branches/42",
+				Commit: &CommitView{
+					SHA: "f9e8d7c6b5a4321",
+					URL: "https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321",
+				},
+				BaseBranch: "main",
+				Members: []MemberView{
+					{
+						Branch:    "feature/auth",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/auth",
+						MergedCommit: &CommitView{
+							SHA: "a1b2c3d4e5f6789",
+							URL: "https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789",
+						},
+						Ahead:  3,
+						Behind: 12,
+						Age:    50 * time.Hour,
+					},
+					{
+						Branch:    "hotfix",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/hotfix",
+						MergedCommit: &CommitView{
+							SHA: "b2c3d4e5f6789a",
+							URL: "https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a",
+						},
+						Behind:         2,
+						Age:            45 * time.Minute,
+						MergedUpstream: true,
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+				"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
+				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) | null | null | null |  |",
+				"| [feature/auth](https://gitlab.com/demo/project/-/tree/feature/auth) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | null | ↑3 ↓12 | 2d | null |  |",
+				"| [hotfix](https://gitlab.com/demo/project/-/tree/hotfix) | null | [b2c3d4e5](https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a) | null | ↑0 ↓2 | 45m | null | :recycle: already merged into main, remove it: `!bb remove hotfix` |",
+			}, "\n"),
+		},
+		{
+			name: "warnings",
+			view: MergeTrainView{
+				Branch: "bb-branches/42",
+				URL:    "https://gitlab.com/demo/project/-/tree/bb-branches/42",
+				Commit: &CommitView{
+					SHA: "f9e8d7c6b5a4321",
+					URL: "https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321",
+				},
+				Members: []MemberView{
+					{
+						Branch:    "feature/auth",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/feature/auth",
+						MergedCommit: &CommitView{
+							SHA: "a1b2c3d4e5f6789",
+							URL: "https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789",
+						},
+						Warnings: []string{"branch feature/auth not found: 404 Not Found", "merge request of feature/auth is temporarily unavailable: a|b"},
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+				"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
+				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) | null | null | null |  |",
+				"| [feature/auth](https://gitlab.com/demo/project/-/tree/feature/auth) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | null | null | null | null | :warning: branch feature/auth not found: 404 Not Found<br>:warning: merge request of feature/auth is temporarily unavailable: a\\|b |",
+			}, "\n"),
+		},
+		{
+			name: "pipelines",
+			view: MergeTrainView{
+				Branch: "bb-branches/42",
+				URL:    "https://gitlab.com/demo/project/-/tree/bb-branches/42",
+				Commit: &CommitView{
+					SHA: "f9e8d7c6b5a4321",
+					URL: "https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321",
+				},
+				Pipeline: &PipelineView{Status: "failed", URL: "https://gitlab.com/demo/project/-/pipelines/2"},
+				Members: []MemberView{
+					{
+						Branch:    "main",
+						BranchURL: "https://gitlab.com/demo/project/-/tree/main",
+						MergedCommit: &CommitView{
+							SHA: "a1b2c3d4e5f6789",
+							URL: "https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789",
+						},
+						Pipeline: &PipelineView{Status: "success", URL: "https://gitlab.com/demo/project/-/pipelines/1"},
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"| Branch | Merge Request | Merged Commit | Latest Commit | Ahead/Behind | Age | Pipeline | Note |",
+				"| ------ | ------------ | ------------- | ------------- | ------------ | --- | -------- | ---- |",
+				"| [bb-branches/42](https://gitlab.com/demo/project/-/tree/bb-branches/42) | null | null | [f9e8d7c6](https://gitlab.com/demo/project/-/commit/f9e8d7c6b5a4321) | null | null | [:x: failed](https://gitlab.com/demo/project/-/pipelines/2) |  |",
+				"| [main](https://gitlab.com/demo/project/-/tree/main) | null | [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) | null | null | null | [:white_check_mark: success](https://gitlab.com/demo/project/-/pipelines/1) |  |",
 			}, "\n"),
 		},
 	}
@@ -180,3 +380,219 @@ func TestMergeTrainView_RenderTable(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeTrainView_RenderResolutions(t *testing.T) {
+	tests := []struct {
+		name string
+		view MergeTrainView
+		want string
+	}{
+		{
+			name: "no resolutions",
+			view: MergeTrainView{},
+			want: "",
+		},
+		{
+			name: "up to date and outdated resolutions",
+			view: MergeTrainView{
+				Resolutions: []ResolutionView{
+					{
+						Branches: [2]string{"feature/auth", "feature/login"},
+						Commit: &CommitView{
+							SHA: "c3d4e5f6789ab",
+							URL: "https://gitlab.com/demo/project/-/commit/c3d4e5f6789ab",
+						},
+					},
+					{
+						Branches: [2]string{"feature/auth", "feature/ui"},
+						Commit: &CommitView{
+							SHA: "d4e5f6789abc",
+							URL: "https://gitlab.com/demo/project/-/commit/d4e5f6789abc",
+						},
+						Outdated: true,
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"| Conflicting Branches | Resolution | Note |",
+				"| -------------------- | ---------- | ---- |",
+				"| `feature/auth`, `feature/login` | [c3d4e5f6](https://gitlab.com/demo/project/-/commit/c3d4e5f6789ab) |  |",
+				"| `feature/auth`, `feature/ui` | [d4e5f678](https://gitlab.com/demo/project/-/commit/d4e5f6789abc) | Outdated, merge the latest branches and run `!bb resolve feature/auth feature/ui <commit>` again |",
+			}, "\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.view.RenderResolutions(); got != tt.want {
+				t.Errorf("MergeTrainView.RenderResolutions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTrainView_RenderAutoResolved(t *testing.T) {
+	view := MergeTrainView{}
+	if got := view.RenderAutoResolved(); got != "" {
+		t.Errorf("MergeTrainView.RenderAutoResolved() = %v, want empty", got)
+	}
+
+	view.AutoResolved = []FileAutoResolution{
+		{Path: "CHANGELOG.md", Strategy: MergeStrategyUnion},
+		{Path: "go.sum", Strategy: MergeStrategyCommand},
+	}
+	want := "- `CHANGELOG.md`: union\n- `go.sum`: command"
+	if got := view.RenderAutoResolved(); got != want {
+		t.Errorf("MergeTrainView.RenderAutoResolved() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeTrainView_RenderHotspots(t *testing.T) {
+	tests := []struct {
+		name string
+		view MergeTrainView
+		want string
+	}{
+		{
+			name: "no hotspots",
+			view: MergeTrainView{
+				Members: []MemberView{
+					{Branch: "feature/auth", DiffStat: &DiffStatView{Files: 1, Added: 2}},
+				},
+			},
+			want: "",
+		},
+		{
+			name: "file modified by two members",
+			view: MergeTrainView{
+				Members: []MemberView{
+					{Branch: "feature/auth", DiffStat: &DiffStatView{Files: 2, Added: 12, Deleted: 3}},
+					{Branch: "feature/login", DiffStat: &DiffStatView{Files: 1, Added: 1, Deleted: 1}},
+				},
+				Hotspots: []HotspotView{
+					{
+						Path: "src/auth.go",
+						Changes: []HotspotChange{
+							{Branch: "feature/auth", Added: 10, Deleted: 3},
+							{Branch: "feature/login", Added: 1, Deleted: 1},
+						},
+					},
+					{
+						Path: "logo.png",
+						Changes: []HotspotChange{
+							{Branch: "feature/auth", Binary: true},
+							{Branch: "feature/login", Binary: true},
+						},
+					},
+				},
+			},
+			want: strings.Join([]string{
+				"| File | Modified By |",
+				"| ---- | ----------- |",
+				"| `src/auth.go` | `feature/auth` (+10 -3), `feature/login` (+1 -1) |",
+				"| `logo.png` | `feature/auth` (binary), `feature/login` (binary) |",
+				"",
+				"<details><summary>diffstat per member</summary>",
+				"",
+				"| Branch | Files | Added | Deleted |",
+				"| ------ | ----- | ----- | ------- |",
+				"| feature/auth | 2 | +12 | -3 |",
+				"| feature/login | 1 | +1 | -1 |",
+				"",
+				"</details>",
+			}, "\n"),
+		},
+		{
+			name: "paths breaking the table",
+			view: MergeTrainView{
+				Hotspots: []HotspotView{
+					{Path: "docs/a|b.md", Changes: []HotspotChange{{Branch: "a", Added: 1}, {Branch: "b", Added: 1}}},
+					{Path: "`odd`.txt", Changes: []HotspotChange{{Branch: "a", Added: 1}, {Branch: "b", Added: 1}}},
+				},
+			},
+			want: strings.Join([]string{
+				"| File | Modified By |",
+				"| ---- | ----------- |",
+				"| `docs/a\\|b.md` | `a` (+1 -0), `b` (+1 -0) |",
+				"| `` `odd`.txt `` | `a` (+1 -0), `b` (+1 -0) |",
+				"",
+				"<details><summary>diffstat per member</summary>",
+				"",
+				"| Branch | Files | Added | Deleted |",
+				"| ------ | ----- | ----- | ------- |",
+				"",
+				"</details>",
+			}, "\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.view.RenderHotspots(); got != tt.want {
+				t.Errorf("MergeTrainView.RenderHotspots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTrainView_RenderUpdates(t *testing.T) {
+	commit := func(sha, title string) CommitView {
+		return CommitView{SHA: sha, URL: "https://gitlab.com/demo/project/-/commit/" + sha, Title: title}
+	}
+	from, to := commit("a1b2c3d4e5f6789", ""), commit("c3d4e5f6789ab12", "")
+	tests := []struct {
+		name string
+		view MergeTrainView
+		want string
+	}{
+		{
+			name: "up to date",
+			view: MergeTrainView{Members: []MemberView{{Branch: "main"}}},
+			want: "",
+		},
+		{
+			name: "outdated",
+			view: MergeTrainView{Members: []MemberView{
+				{Branch: "main"},
+				{Branch: "feature/auth", Updates: &MemberDiffView{
+					Branch:       "feature/auth",
+					From:         &from,
+					To:           &to,
+					Commits:      []CommitView{commit("c3d4e5f6789ab12", "Check tokens"), commit("b2c3d4e5f6789a1", "Add login")},
+					TotalCommits: 3,
+					Files:        []FileDiffStat{{Path: "src/auth.go", Added: 10, Deleted: 3}, {Path: "logo.png", Binary: true}},
+					TotalFiles:   2,
+					Added:        10,
+					Deleted:      3,
+					CompareURL:   "https://gitlab.com/demo/project/-/compare/a1b2c3d4e5f6789...c3d4e5f6789ab12",
+				}},
+			}},
+			want: strings.Join([]string{
+				"<details><summary>feature/auth: 3 commits, 2 files changed (+10 -3)</summary>",
+				"",
+				"Changes of `feature/auth` from [a1b2c3d4](https://gitlab.com/demo/project/-/commit/a1b2c3d4e5f6789) to [c3d4e5f6](https://gitlab.com/demo/project/-/commit/c3d4e5f6789ab12), latest first:",
+				"",
+				"- [c3d4e5f6](https://gitlab.com/demo/project/-/commit/c3d4e5f6789ab12) Check tokens",
+				"- [b2c3d4e5](https://gitlab.com/demo/project/-/commit/b2c3d4e5f6789a1) Add login",
+				"- … 1 more",
+				"",
+				"| File | Added | Deleted |",
+				"| ---- | ----- | ------- |",
+				"| `src/auth.go` | +10 | -3 |",
+				"| `logo.png` | binary | binary |",
+				"",
+				"[Compare all changes on GitLab](https://gitlab.com/demo/project/-/compare/a1b2c3d4e5f6789...c3d4e5f6789ab12)",
+				"",
+				"</details>",
+			}, "\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.view.RenderUpdates(); got != tt.want {
+				t.Errorf("MergeTrainView.RenderUpdates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}